@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Bibek99/sqlproc"
+)
+
+// subcommands maps the first CLI argument to a schema migration command.
+var subcommands = map[string]func(args []string) error{
+	"rollback":   runRollback,
+	"migrate-to": runMigrateTo,
+	"repair":     runRepair,
+	"status":     runStatus,
+}
+
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	dbURL := fs.String("db", "", "Database connection string (postgres)")
+	steps := fs.Int("steps", 1, "Number of applied schema migrations to revert")
+	lock := lockFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlproc rollback -db <url> [-steps N] [-lock-key K] [-lock-timeout D]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	return withLockedMigrator(*dbURL, lock, func(ctx context.Context, m *sqlproc.SchemaMigrator) error {
+		if err := m.Rollback(ctx, *steps); err != nil {
+			return err
+		}
+		log.Printf("✅ Rolled back %d schema migration(s)", *steps)
+		return nil
+	})
+}
+
+func runMigrateTo(args []string) error {
+	fs := flag.NewFlagSet("migrate-to", flag.ExitOnError)
+	dbURL := fs.String("db", "", "Database connection string (postgres)")
+	migrationsArg := fs.String("migrations", "", "Comma-separated list of schema migration files or directories")
+	version := fs.Int64("version", -1, "Target schema version (0 reverts every migration)")
+	lock := lockFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlproc migrate-to -db <url> -migrations <path> -version N [-lock-key K] [-lock-timeout D]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *version < 0 {
+		return fmt.Errorf("-version is required")
+	}
+
+	migrations, err := loadMigrations(*migrationsArg)
+	if err != nil {
+		return err
+	}
+	return withLockedMigrator(*dbURL, lock, func(ctx context.Context, m *sqlproc.SchemaMigrator) error {
+		if err := m.MigrateTo(ctx, migrations, *version); err != nil {
+			return err
+		}
+		log.Printf("✅ Schema is at version %d", *version)
+		return nil
+	})
+}
+
+func runRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dbURL := fs.String("db", "", "Database connection string (postgres)")
+	migrationsArg := fs.String("migrations", "", "Comma-separated list of schema migration files or directories")
+	lock := lockFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlproc repair -db <url> -migrations <path> [-lock-key K] [-lock-timeout D]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	migrations, err := loadMigrations(*migrationsArg)
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return fmt.Errorf("-migrations is required")
+	}
+	return withLockedMigrator(*dbURL, lock, func(ctx context.Context, m *sqlproc.SchemaMigrator) error {
+		if err := m.Repair(ctx, migrations); err != nil {
+			return err
+		}
+		log.Printf("✅ Recorded checksums for %d schema migration file(s)", len(migrations))
+		return nil
+	})
+}
+
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	dbURL := fs.String("db", "", "Database connection string (postgres)")
+	migrationsArg := fs.String("migrations", "", "Comma-separated list of schema migration files or directories")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlproc status -db <url> [-migrations <path>]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	migrations, err := loadMigrations(*migrationsArg)
+	if err != nil {
+		return err
+	}
+	return withDB(*dbURL, func(ctx context.Context, db *sql.DB) error {
+		report, err := sqlproc.NewSchemaMigrator(db).Status(ctx, migrations)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, status := range report {
+			appliedAt := "-"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, status.State, appliedAt)
+		}
+		return w.Flush()
+	})
+}
+
+func loadMigrations(inputs string) ([]*sqlproc.SchemaMigration, error) {
+	files, err := sqlproc.ResolveFiles(splitInputs(inputs))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	return sqlproc.LoadSchemaMigrations(files)
+}
+
+// migrationLock holds the -lock-key and -lock-timeout flags of commands that
+// change the schema.
+type migrationLock struct {
+	key     *int64
+	timeout *time.Duration
+}
+
+func lockFlags(fs *flag.FlagSet) migrationLock {
+	return migrationLock{
+		key:     fs.Int64("lock-key", sqlproc.DefaultMigrationLockKey, "PostgreSQL advisory lock key held while migrating"),
+		timeout: fs.Duration("lock-timeout", 0, "Maximum time to wait for the migration lock (0 waits for the overall timeout)"),
+	}
+}
+
+// withLockedMigrator is like withDB but holds the migration lock, so the
+// command cannot race a concurrent sqlproc run.
+func withLockedMigrator(dbURL string, lock migrationLock, fn func(ctx context.Context, m *sqlproc.SchemaMigrator) error) error {
+	return withDB(dbURL, func(ctx context.Context, db *sql.DB) error {
+		return sqlproc.WithMigrationLock(ctx, db, *lock.key, *lock.timeout, nil, func(m *sqlproc.SchemaMigrator) error {
+			return fn(ctx, m)
+		})
+	})
+}
+
+// withDB opens and pings the database, then runs fn with a timeout.
+func withDB(dbURL string, fn func(ctx context.Context, db *sql.DB) error) error {
+	if dbURL == "" {
+		return fmt.Errorf("-db is required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping db: %w", err)
+	}
+	return fn(ctx, db)
+}
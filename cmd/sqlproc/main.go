@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"os"
 	"strings"
 	"time"
 
@@ -12,6 +13,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalf("sqlproc %s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	var (
 		dbURL         = flag.String("db", "", "Database connection string (postgres)")
 		filesArg      = flag.String("files", "", "Comma-separated list of SQL files or directories")
@@ -25,16 +35,46 @@ func main() {
 		schemaPkg     = flag.String("schema-pkg", "", "Package name for schema models (defaults to -pkg)")
 		schemaList    = flag.String("schemas", "public", "Comma-separated database schemas to introspect (use * for all)")
 		schemaTag     = flag.String("schema-tag", "db,json", "Comma-separated struct tag keys (e.g. \"db,json\")")
+		schemaSort    = flag.Bool("schema-sort-columns", false, "Order schema model fields alphabetically instead of by column position")
+		schemaKinds   = flag.String("schema-kinds", "", "Comma-separated relation kinds to introspect: table, view, materialized_view, foreign_table, partitioned_table (default all)")
+		schemaExclude = flag.String("schema-exclude-kinds", "", "Comma-separated relation kinds to skip during introspection")
+		schemaRels    = flag.Bool("schema-relations", false, "Add a key method per foreign key to schema models")
+		nullStyle     = flag.String("null-style", "pointer", "Go representation of nullable values: pointer, sql or generic")
+		arrayAdapter  = flag.String("array-adapter", sqlproc.DefaultArrayAdapter, "Qualified function wrapping array args and scan targets (\"none\" to disable)")
+		paramsStruct  = flag.Bool("params-struct", false, "Pass procedure params as a <Name>Params struct")
+		emitMock      = flag.Bool("emit-mock", false, "Also write mock_querier.go with a function-field Querier fake")
+		schemaQueries = flag.Bool("schema-queries", false, "Also write schema_queries.go with CRUD methods for introspected tables (requires -schema-models in the same package)")
+		drift         = flag.String("drift", "fail", "Handling of applied schema migrations whose files changed: fail, warn or ignore")
+		noLock        = flag.Bool("no-lock", false, "Do not take an advisory lock around migrations")
+		lockKey       = flag.Int64("lock-key", sqlproc.DefaultMigrationLockKey, "PostgreSQL advisory lock key held while migrating")
+		forceProcs    = flag.Bool("force-procedures", false, "Re-apply stored procedures even when their definition is unchanged")
+		pruneProcs    = flag.Bool("prune", false, "Drop stored procedures applied by sqlproc that are no longer in -files")
+		dropCascade   = flag.Bool("drop-cascade", false, "Allow dropping routines that must be recreated or pruned together with their dependent objects")
+		dropUntracked = flag.Bool("drop-untracked-overloads", false, "Drop overloads of applied procedures that sqlproc did not create instead of warning about them")
+		txScope       = flag.String("tx-scope", "migration", "Migrations applied in one transaction: migration (each on its own), procedures or all")
+		dryRun        = flag.Bool("dry-run", false, "Print pending schema migrations and changed procedures without applying or generating anything")
+		lockTimeout   = flag.Duration("lock-timeout", 0, "Maximum time to wait for the migration lock (0 waits for the overall timeout)")
 	)
+	var overrideSpecs stringList
+	flag.Var(&overrideSpecs, "type-override", "Go type override as match=goType, e.g. numeric=github.com/shopspring/decimal.Decimal users.id=int64 or type:public.mood=string (repeatable)")
 	flag.Parse()
 
+	var overrides []sqlproc.TypeOverride
+	for _, spec := range overrideSpecs {
+		override, err := sqlproc.ParseTypeOverride(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		overrides = append(overrides, override)
+	}
+
 	if *filesArg == "" && strings.TrimSpace(*migrationsArg) == "" && !*schemaModels {
 		log.Fatal("provide -files, -migrations, or enable -schema-models")
 	}
 
 	sqlInputs := splitInputs(*filesArg)
 	migrationInputs := splitInputs(*migrationsArg)
-	if !*skipMigrate && *dbURL == "" {
+	if (!*skipMigrate || *dryRun) && *dbURL == "" {
 		log.Fatal("-db is required unless -skip-migrate is set")
 	}
 
@@ -49,10 +89,16 @@ func main() {
 		}
 		tag := strings.TrimSpace(*schemaTag)
 		schemaOpts = &sqlproc.SchemaModelOptions{
-			Schemas:     schemas,
-			OutputDir:   firstNonEmpty(*schemaOut, *outputDir),
-			PackageName: firstNonEmpty(*schemaPkg, *packageName),
-			StructTag:   tag,
+			Schemas:         schemas,
+			OutputDir:       firstNonEmpty(*schemaOut, *outputDir),
+			PackageName:     firstNonEmpty(*schemaPkg, *packageName),
+			StructTag:       tag,
+			NullStyle:       sqlproc.NullStyle(*nullStyle),
+			TypeOverrides:   overrides,
+			SortColumns:     *schemaSort,
+			IncludeKinds:    relationKinds(*schemaKinds),
+			ExcludeKinds:    relationKinds(*schemaExclude),
+			RelationHelpers: *schemaRels,
 		}
 	}
 
@@ -60,19 +106,42 @@ func main() {
 	defer cancel()
 
 	result, err := sqlproc.Run(ctx, sqlproc.PipelineOptions{
-		SQLInputs:       sqlInputs,
-		MigrationInputs: migrationInputs,
-		OutputDir:       *outputDir,
-		PackageName:     *packageName,
-		SkipMigrate:     *skipMigrate,
-		SkipGenerate:    *skipGenerate,
-		DBURL:           *dbURL,
-		SchemaModels:    schemaOpts,
+		SQLInputs:              sqlInputs,
+		MigrationInputs:        migrationInputs,
+		OutputDir:              *outputDir,
+		PackageName:            *packageName,
+		SkipMigrate:            *skipMigrate,
+		SkipGenerate:           *skipGenerate,
+		DBURL:                  *dbURL,
+		SchemaModels:           schemaOpts,
+		DriftPolicy:            sqlproc.DriftPolicy(*drift),
+		SkipMigrationLock:      *noLock,
+		MigrationLockKey:       *lockKey,
+		MigrationLockTimeout:   *lockTimeout,
+		DryRun:                 *dryRun,
+		ForceProcedures:        *forceProcs,
+		PruneProcedures:        *pruneProcs,
+		DropCascade:            *dropCascade,
+		DropUntrackedOverloads: *dropUntracked,
+		TransactionScope:       sqlproc.TransactionScope(*txScope),
+		GeneratorOptions: sqlproc.GeneratorOptions{
+			NullStyle:     sqlproc.NullStyle(*nullStyle),
+			TypeOverrides: overrides,
+			ArrayAdapter:  *arrayAdapter,
+			ParamsStruct:  *paramsStruct,
+			EmitMock:      *emitMock,
+			SchemaQueries: *schemaQueries,
+		},
 	})
 	if err != nil {
 		log.Fatalf("sqlproc failed: %v", err)
 	}
 
+	if result.Plan != nil {
+		log.Printf("✅ Plan: %d pending schema migration(s), %d changed procedure(s), %d routine(s) to drop", len(result.Plan.PendingMigrations), len(result.Plan.ChangedProcedures), len(result.Plan.DroppedRoutines))
+		return
+	}
+
 	log.Printf("✅ Processed %d procedure(s)", len(result.Procedures))
 	if len(result.SchemaMigrations) > 0 {
 		log.Printf("✅ Applied %d schema migration(s)", len(result.SchemaMigrations))
@@ -85,6 +154,18 @@ func main() {
 	}
 }
 
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func splitInputs(input string) []string {
 	parts := strings.Split(input, ",")
 	var cleaned []string
@@ -97,6 +178,14 @@ func splitInputs(input string) []string {
 	return cleaned
 }
 
+func relationKinds(input string) []sqlproc.RelationKind {
+	var kinds []sqlproc.RelationKind
+	for _, kind := range splitInputs(input) {
+		kinds = append(kinds, sqlproc.RelationKind(kind))
+	}
+	return kinds
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, v := range values {
 		if strings.TrimSpace(v) != "" {
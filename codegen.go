@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"go/format"
+	"go/token"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -15,51 +17,242 @@ import (
 type CodeGenerator struct {
 	OutputDir   string
 	PackageName string
+	// NullStyle selects the Go representation of nullable params and columns.
+	NullStyle NullStyle
+	// TypeOverrides replace the Go types chosen for database types or columns.
+	TypeOverrides []TypeOverride
+	// ArrayAdapter is the qualified function wrapping array args and scan
+	// targets. Defaults to DefaultArrayAdapter; "none" passes slices as-is.
+	ArrayAdapter string
+	// Enums are PostgreSQL enum types rendered to enums.go and used for
+	// params and columns of those types.
+	Enums []*Enum
+	// SchemaTables are tables whose schema model structs live in the same
+	// package. Procedures returning SETOF one of them reuse its struct.
+	SchemaTables []*Table
+	// ParamsStruct passes the params of every procedure as a <Name>Params
+	// struct. Individual procedures opt in with "-- option: params_struct".
+	ParamsStruct bool
+	// EmitMock writes mock_querier.go with a MockQuerier whose methods call
+	// user-supplied function fields.
+	EmitMock bool
+	// SchemaQueries writes schema_queries.go with Get<Table>ByPK, List<Table>,
+	// Insert<Table>, Update<Table> and Delete<Table> methods for SchemaTables.
+	SchemaQueries bool
+
+	overrides *typeOverrides
+	tables    map[string]*Table
 }
 
+// DefaultArrayAdapter wraps Go slices for PostgreSQL arrays with lib/pq.
+const DefaultArrayAdapter = "github.com/lib/pq.Array"
+
 func (cg *CodeGenerator) Generate(procs []*Procedure) error {
-	if len(procs) == 0 {
+	var tableProcs []*Procedure
+	if cg.SchemaQueries {
+		tableProcs = tableProcedures(cg.SchemaTables)
+		if err := checkMethodNames(procs, tableProcs); err != nil {
+			return err
+		}
+	}
+	if len(procs) == 0 && len(tableProcs) == 0 {
 		return nil
 	}
 	if cg.PackageName == "" {
 		cg.PackageName = "generated"
 	}
-
-	if err := cg.writeFile("db.go", cg.render(dbTemplate, procs)); err != nil {
+	if err := cg.NullStyle.validate(); err != nil {
 		return err
 	}
-	if err := cg.writeFile("models.go", cg.render(modelsTemplate, procs)); err != nil {
+	overrides, err := compileTypeOverrides(cg.TypeOverrides)
+	if err != nil {
+		return err
+	}
+	cg.overrides = overrides
+	if cg.ArrayAdapter == "" {
+		cg.ArrayAdapter = DefaultArrayAdapter
+	}
+	if cg.ArrayAdapter != "none" {
+		// qualify also rejects package names taken by generated code or
+		// type overrides, which wrap could not report.
+		if _, err := newTypeMapper(cg.NullStyle, cg.overrides, cg.Enums).qualify(cg.ArrayAdapter); err != nil {
+			return fmt.Errorf("array adapter: %w", err)
+		}
+	}
+	for _, p := range procs {
+		if p.Kind != ReturnExec && len(p.Returns) == 0 && p.ReturnType != "" {
+			return fmt.Errorf("%s: return type %s has not been resolved; see ResolveReturnTypes", p.Name, p.ReturnType)
+		}
+	}
+	cg.tables = make(map[string]*Table, len(cg.SchemaTables)*2)
+	for _, t := range cg.SchemaTables {
+		cg.tables[t.Schema+"."+t.Name] = t
+		if _, taken := cg.tables[t.Name]; !taken || t.Schema == "public" {
+			cg.tables[t.Name] = t
+		}
+	}
+
+	all := append(append([]*Procedure(nil), procs...), tableProcs...)
+	if err := cg.writeFile("db.go", cg.render(dbTemplate, all, "context", "database/sql")); err != nil {
 		return err
 	}
-	if err := cg.writeFile("queries.go", cg.render(queriesTemplate, procs)); err != nil {
+	if err := cg.writeFile("models.go", cg.render(modelsTemplate, all)); err != nil {
 		return err
 	}
+	if len(procs) > 0 {
+		if err := cg.writeFile("queries.go", cg.render(queriesTemplate, procs, "context")); err != nil {
+			return err
+		}
+	}
+	if len(tableProcs) > 0 {
+		if err := cg.writeFile("schema_queries.go", cg.render(queriesTemplate, tableProcs, "context")); err != nil {
+			return err
+		}
+	}
+	if cg.EmitMock {
+		if err := cg.writeFile("mock_querier.go", cg.render(mockTemplate, all, "context")); err != nil {
+			return err
+		}
+	}
+	if len(cg.Enums) > 0 {
+		if _, err := writeEnumsFile(cg.OutputDir, cg.PackageName, cg.Enums); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (cg *CodeGenerator) render(tmplStr string, procs []*Procedure) []byte {
+// render executes a template body and prepends the package clause plus the
+// given imports and any imports required by the mapped Go types.
+func (cg *CodeGenerator) render(tmplStr string, procs []*Procedure, imports ...string) []byte {
+	types := newTypeMapper(cg.NullStyle, cg.overrides, cg.Enums)
+	arrays := &arrayWrapper{types: types, adapter: cg.ArrayAdapter}
 	tmpl := template.Must(template.New("sqlproc").Funcs(template.FuncMap{
-		"GoName":          toGoName,
-		"GoField":         toGoExportedField,
-		"GoType":          sqlTypeToGo,
-		"ReturnKind":      func(p *Procedure, want ReturnKind) bool { return p.Kind == want },
-		"ParamSignature":  paramSignature,
-		"ArgList":         argList,
+		"GoName":  toGoName,
+		"GoField": toGoExportedField,
+		"ColumnType": func(p *Procedure, col Column) string {
+			return types.columnType(procScopes(p), col.Name, col.DBType, col.Nullable)
+		},
+		"ReturnKind": func(p *Procedure, want ReturnKind) bool { return p.Kind == want },
+		"ParamType": func(p *Procedure, param Param) string {
+			return types.columnType(procScopes(p), param.Name, param.DBType, param.Nullable)
+		},
+		"ParamsStruct":    cg.paramsStruct,
+		"ParamSignature":  func(p *Procedure) string { return paramSignature(p, types, cg.paramsStruct(p)) },
+		"MethodSignature": func(p *Procedure) string { return methodSignature(p, types, cg.paramsStruct(p)) },
+		"MethodResults":   methodResults,
+		"ParamNames":      func(p *Procedure) string { return paramNames(p, cg.paramsStruct(p)) },
+		"ArgList":         func(p *Procedure) string { return arrays.argList(p, cg.paramsStruct(p)) },
 		"PlaceholderList": placeholderList,
 		"QueryLiteral":    queryLiteral,
-		"ScanTargets":     scanTargets,
-		"HasParams":       func(p *Procedure) bool { return len(p.Params) > 0 },
-		"JSONTag":         jsonTag,
+		"IsRefresh":       func(p *Procedure) bool { return p.refresh },
+		"ConcurrentQueryLiteral": func(p *Procedure) string {
+			return strconv.Quote(strings.Replace(p.query, "MATERIALIZED VIEW ", "MATERIALIZED VIEW CONCURRENTLY ", 1))
+		},
+		"ScanTargets": arrays.scanTargets,
+		"HasParams":   func(p *Procedure) bool { return len(p.InputParams()) > 0 },
+		"JSONTag":     jsonTag,
+		"DocLines":    func(p *Procedure) []string { return docLines(p.Doc) },
+		"RowAlias":    cg.rowAlias,
 	}).Parse(tmplStr))
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, map[string]any{
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, map[string]any{
 		"Package":    cg.PackageName,
 		"Procedures": procs,
-		"UsesTime":   usesTime(procs),
 	}); err != nil {
 		panic(err)
 	}
+	return formatGoFile(cg.PackageName, append(imports, types.imports()...), body.Bytes())
+}
+
+// checkMethodNames reports a generated table query whose method name is
+// already taken by a procedure.
+func checkMethodNames(procs, tableProcs []*Procedure) error {
+	taken := make(map[string]*Procedure, len(procs))
+	for _, p := range procs {
+		taken[toGoName(p.Name)] = p
+	}
+	for _, p := range tableProcs {
+		if other, ok := taken[toGoName(p.Name)]; ok {
+			return fmt.Errorf("schema query %s for table %s collides with procedure %s (%s)", toGoName(p.Name), p.SQLName, other.Name, other.File)
+		}
+	}
+	return nil
+}
+
+// rowAlias returns the schema model struct a procedure's row type can alias,
+// or "" when the procedure needs a struct of its own. The struct is reused
+// only when every column maps to the same field and Go type.
+func (cg *CodeGenerator) rowAlias(p *Procedure) string {
+	if p.ReturnType == "" {
+		return ""
+	}
+	table := cg.tables[rowTypeKey(p.ReturnType)]
+	if table == nil || len(table.Columns) != len(p.Returns) {
+		return ""
+	}
+	types := newTypeMapper(cg.NullStyle, cg.overrides, cg.Enums)
+	fields := make(map[string]string, len(table.Columns))
+	for _, col := range table.Columns {
+		fields[toGoExportedField(col.Name)] = types.columnType(tableScopes(table), col.Name, col.DBType, col.Nullable)
+	}
+	for _, col := range p.Returns {
+		goType, ok := fields[toGoExportedField(col.Name)]
+		if !ok || goType != types.columnType(procScopes(p), col.Name, col.DBType, col.Nullable) {
+			return ""
+		}
+	}
+	return goStructName(table.Schema, table.Name)
+}
+
+func (cg *CodeGenerator) writeFile(name string, contents []byte) error {
+	path := filepath.Join(cg.OutputDir, name)
+	return os.WriteFile(path, contents, 0o644)
+}
+
+// formatGoFile assembles a Go source file from a package name, import specs
+// ("path" or "name path", see importSpec) and body, then gofmts it. Standard
+// library imports are grouped first.
+func formatGoFile(pkg string, imports []string, body []byte) []byte {
+	var std, other []string
+	seen := make(map[string]bool)
+	for _, spec := range imports {
+		if seen[spec] {
+			continue
+		}
+		seen[spec] = true
+		if strings.Contains(strings.Split(importPath(spec), "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	byPath := func(specs []string) {
+		sort.Slice(specs, func(i, j int) bool { return importPath(specs[i]) < importPath(specs[j]) })
+	}
+	byPath(std)
+	byPath(other)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	switch {
+	case len(std)+len(other) == 1:
+		fmt.Fprintf(&buf, "import %s\n\n", importLine(append(std, other...)[0]))
+	case len(std)+len(other) > 1:
+		buf.WriteString("import (\n")
+		for _, spec := range std {
+			fmt.Fprintf(&buf, "\t%s\n", importLine(spec))
+		}
+		if len(std) > 0 && len(other) > 0 {
+			buf.WriteString("\n")
+		}
+		for _, spec := range other {
+			fmt.Fprintf(&buf, "\t%s\n", importLine(spec))
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.Write(body)
 
 	formatted, err := format.Source(buf.Bytes())
 	if err != nil {
@@ -69,20 +262,22 @@ func (cg *CodeGenerator) render(tmplStr string, procs []*Procedure) []byte {
 	return formatted
 }
 
-func (cg *CodeGenerator) writeFile(name string, contents []byte) error {
-	path := filepath.Join(cg.OutputDir, name)
-	return os.WriteFile(path, contents, 0o644)
+// importPath returns the path of an import spec.
+func importPath(spec string) string {
+	_, path, aliased := strings.Cut(spec, " ")
+	if !aliased {
+		return spec
+	}
+	return path
 }
 
-func usesTime(procs []*Procedure) bool {
-	for _, proc := range procs {
-		for _, col := range proc.Returns {
-			if sqlTypeToGo(col.DBType) == "time.Time" {
-				return true
-			}
-		}
+// importLine renders an import spec as it appears in an import declaration.
+func importLine(spec string) string {
+	name, path, aliased := strings.Cut(spec, " ")
+	if !aliased {
+		return strconv.Quote(spec)
 	}
-	return false
+	return name + " " + strconv.Quote(path)
 }
 
 func toGoName(name string) string {
@@ -93,6 +288,22 @@ func toGoExportedField(name string) string {
 	return toCamel(name, true)
 }
 
+// methodLocals are the identifiers generated method bodies declare.
+var methodLocals = map[string]bool{
+	"ctx": true, "q": true, "m": true, "query": true, "row": true, "rows": true,
+	"dest": true, "result": true, "err": true, "arg": true,
+}
+
+// goParamName returns the Go argument name for a param, suffixed with "Arg"
+// when it would be a keyword or clash with a local of the generated method.
+func goParamName(name string) string {
+	goName := toCamel(name, false)
+	if token.IsKeyword(goName) || methodLocals[goName] {
+		return goName + "Arg"
+	}
+	return goName
+}
+
 func hasDelimiter(s string) bool {
 	for _, r := range s {
 		if r == '_' || r == '-' || r == ' ' {
@@ -132,6 +343,9 @@ func toCamel(s string, export bool) string {
 
 func sqlTypeToGo(dbType string) string {
 	dbType = strings.ToLower(strings.TrimSpace(dbType))
+	if elem, ok := arrayElemType(dbType); ok {
+		return "[]" + sqlTypeToGo(elem)
+	}
 	switch {
 	case strings.HasPrefix(dbType, "int"):
 		return "int32"
@@ -158,6 +372,43 @@ func sqlTypeToGo(dbType string) string {
 	}
 }
 
+// arrayElemType reports whether dbType is a PostgreSQL array ("int[]",
+// "integer array" or the "_int4" udt name) and returns its element type.
+// Each call strips one dimension.
+func arrayElemType(dbType string) (string, bool) {
+	dbType = normalizeType(dbType)
+	switch {
+	case strings.HasSuffix(dbType, "]"):
+		if idx := strings.LastIndex(dbType, "["); idx > 0 {
+			return strings.TrimSpace(dbType[:idx]), true
+		}
+	case strings.HasSuffix(dbType, " array"):
+		return strings.TrimSpace(strings.TrimSuffix(dbType, " array")), true
+	case strings.HasPrefix(dbType, "_") && len(dbType) > 1:
+		return dbType[1:], true
+	}
+	return "", false
+}
+
+func isArrayType(dbType string) bool {
+	_, ok := arrayElemType(dbType)
+	return ok
+}
+
+// docLines splits documentation into the lines of a Go comment, dropping
+// trailing whitespace and repeated blank lines.
+func docLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
 func jsonTag(name string) string {
 	tagValue := toCamel(name, false)
 	if tagValue == "" {
@@ -166,35 +417,110 @@ func jsonTag(name string) string {
 	return fmt.Sprintf("`json:\"%s\"`", tagValue)
 }
 
-func paramSignature(p *Procedure) string {
-	if len(p.Params) == 0 {
+// paramsStruct reports whether a procedure's params are passed as a struct.
+// Materialized view refreshes always take their concurrently flag directly.
+func (cg *CodeGenerator) paramsStruct(p *Procedure) bool {
+	return (cg.ParamsStruct || p.HasOption(OptionParamsStruct)) && len(p.InputParams()) > 0 && !p.refresh
+}
+
+func paramSignature(p *Procedure, types *typeMapper, asStruct bool) string {
+	params := p.InputParams()
+	if len(params) == 0 {
 		return ""
 	}
+	if asStruct {
+		return ", arg " + toGoName(p.Name) + "Params"
+	}
 	var parts []string
-	for _, param := range p.Params {
-		parts = append(parts, fmt.Sprintf("%s %s", toCamel(param.Name, false), sqlTypeToGo(param.DBType)))
+	for _, param := range params {
+		goType := types.columnType(procScopes(p), param.Name, param.DBType, param.Nullable)
+		parts = append(parts, fmt.Sprintf("%s %s", goParamName(param.Name), goType))
 	}
 	return ", " + strings.Join(parts, ", ")
 }
 
-func argList(p *Procedure) string {
-	if len(p.Params) == 0 {
+// methodSignature renders the name, params and results of a generated method.
+func methodSignature(p *Procedure, types *typeMapper, asStruct bool) string {
+	return fmt.Sprintf("%s(ctx context.Context%s) %s", toGoName(p.Name), paramSignature(p, types, asStruct), methodResults(p))
+}
+
+func methodResults(p *Procedure) string {
+	row := toGoName(p.Name) + "Row"
+	switch p.Kind {
+	case ReturnExec:
+		return "error"
+	case ReturnOne:
+		return "(" + row + ", error)"
+	default:
+		return "([]" + row + ", error)"
+	}
+}
+
+// paramNames lists the Go argument names of a generated method after ctx.
+func paramNames(p *Procedure, asStruct bool) string {
+	params := p.InputParams()
+	if len(params) == 0 {
+		return ""
+	}
+	if asStruct {
+		return ", arg"
+	}
+	names := make([]string, 0, len(params))
+	for _, param := range params {
+		names = append(names, goParamName(param.Name))
+	}
+	return ", " + strings.Join(names, ", ")
+}
+
+// arrayWrapper wraps array values in the configured driver adapter (such as
+// pq.Array) when they are bound as args or used as scan targets.
+type arrayWrapper struct {
+	types   *typeMapper
+	adapter string
+}
+
+func (w *arrayWrapper) wrap(expr string, scopes []string, name, dbType string, nullable bool) string {
+	if w.adapter == "" || w.adapter == "none" || !isArrayType(dbType) {
+		return expr
+	}
+	if !strings.HasPrefix(w.types.columnType(scopes, name, dbType, nullable), "[]") {
+		// overridden to a type that handles arrays itself
+		return expr
+	}
+	fn, err := w.types.qualify(w.adapter)
+	if err != nil {
+		// Generate validates the adapter before rendering.
+		panic(err)
+	}
+	return fn + "(" + expr + ")"
+}
+
+func (w *arrayWrapper) argList(p *Procedure, asStruct bool) string {
+	params := p.InputParams()
+	if len(params) == 0 || p.refresh {
 		return ""
 	}
 	var args []string
-	for _, param := range p.Params {
-		args = append(args, toCamel(param.Name, false))
+	for _, param := range params {
+		expr := goParamName(param.Name)
+		if asStruct {
+			expr = "arg." + toGoExportedField(param.Name)
+		}
+		args = append(args, w.wrap(expr, procScopes(p), param.Name, param.DBType, param.Nullable))
 	}
 	return ", " + strings.Join(args, ", ")
 }
 
 func placeholderList(p *Procedure) string {
-	if len(p.Params) == 0 {
-		return ""
-	}
-	list := make([]string, len(p.Params))
-	for i := range p.Params {
-		list[i] = fmt.Sprintf("$%d", i+1)
+	var list []string
+	for _, param := range p.Params {
+		switch {
+		case param.Mode.IsInput():
+			list = append(list, fmt.Sprintf("$%d", len(list)+1))
+		case p.IsProcedure():
+			// CALL requires a placeholder for OUT arguments of procedures.
+			list = append(list, "NULL")
+		}
 	}
 	return strings.Join(list, ", ")
 }
@@ -216,6 +542,12 @@ func callSQL(p *Procedure) string {
 }
 
 func selectSQL(p *Procedure) string {
+	if p.query != "" {
+		return p.query
+	}
+	if p.IsProcedure() {
+		return "CALL " + callSQL(p)
+	}
 	if p.Kind == ReturnExec {
 		return "SELECT " + callSQL(p)
 	}
@@ -227,25 +559,18 @@ func queryLiteral(p *Procedure) string {
 	return strconv.Quote(sql)
 }
 
-func scanTargets(p *Procedure) string {
+func (w *arrayWrapper) scanTargets(p *Procedure) string {
 	if len(p.Returns) == 0 {
 		return ""
 	}
 	var parts []string
 	for _, col := range p.Returns {
-		parts = append(parts, "&dest."+toGoExportedField(col.Name))
+		parts = append(parts, w.wrap("&dest."+toGoExportedField(col.Name), procScopes(p), col.Name, col.DBType, col.Nullable))
 	}
 	return strings.Join(parts, ", ")
 }
 
-const dbTemplate = `package {{ .Package }}
-
-import (
-	"context"
-	"database/sql"
-)
-
-type DBTX interface {
+const dbTemplate = `type DBTX interface {
 	ExecContext(context.Context, string, ...any) (sql.Result, error)
 	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
 	QueryRowContext(context.Context, string, ...any) *sql.Row
@@ -262,33 +587,52 @@ func New(db DBTX) *Queries {
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{db: tx}
 }
-`
 
-const modelsTemplate = `package {{ .Package }}
+// Querier is implemented by *Queries and lists every generated method.
+type Querier interface {
+	{{- range .Procedures }}
+	{{ MethodSignature . }}
+	{{- end }}
+}
+
+var _ Querier = (*Queries)(nil)
+`
 
-{{- if .UsesTime }}
-import "time"
-{{- end }}
+const modelsTemplate = `{{ range .Procedures -}}
+{{ $proc := . -}}
+{{ if ParamsStruct . -}}
+type {{ GoName .Name }}Params struct {
+	{{- range .InputParams }}
+	{{ GoField .Name }} {{ ParamType $proc . }} {{ JSONTag .Name }}
+	{{- end }}
+}
 
-{{ range .Procedures -}}
+{{ end -}}
 {{ if not (ReturnKind . ":exec") -}}
+{{ if RowAlias . -}}
+type {{ GoName .Name }}Row = {{ RowAlias . }}
+{{ else -}}
 type {{ GoName .Name }}Row struct {
 	{{- range .Returns }}
-	{{ GoField .Name }} {{ GoType .DBType }} {{ JSONTag .Name }}
+	{{ GoField .Name }} {{ ColumnType $proc . }} {{ JSONTag .Name }}
 	{{- end }}
 }
 {{ end -}}
+{{ end -}}
 
 {{ end }}
 `
 
-const queriesTemplate = `package {{ .Package }}
-
-import "context"
-
-{{ range .Procedures -}}
-func (q *Queries) {{ GoName .Name }}(ctx context.Context{{ ParamSignature . }}) {{ if ReturnKind . ":exec" }}error{{ else if ReturnKind . ":one" }}({{ GoName .Name }}Row, error){{ else }}([]{{ GoName .Name }}Row, error){{ end }} {
+const queriesTemplate = `{{ range .Procedures -}}
+{{ range DocLines . }}//{{ if . }} {{ . }}{{ end }}
+{{ end -}}
+func (q *Queries) {{ MethodSignature . }} {
 	query := {{ QueryLiteral . }}
+	{{ if IsRefresh . -}}
+	if concurrently {
+		query = {{ ConcurrentQueryLiteral . }}
+	}
+	{{ end -}}
 	{{ if ReturnKind . ":exec" -}}
 	_, err := q.db.ExecContext(ctx, query{{ ArgList . }})
 	return err
@@ -320,3 +664,21 @@ func (q *Queries) {{ GoName .Name }}(ctx context.Context{{ ParamSignature . }})
 
 {{ end }}
 `
+
+const mockTemplate = `// MockQuerier is a Querier whose methods call the matching function fields.
+// Calling a method whose field is nil panics.
+type MockQuerier struct {
+	{{- range .Procedures }}
+	{{ GoName .Name }}Func func(ctx context.Context{{ ParamSignature . }}) {{ MethodResults . }}
+	{{- end }}
+}
+
+var _ Querier = (*MockQuerier)(nil)
+{{ range .Procedures }}
+func (m *MockQuerier) {{ MethodSignature . }} {
+	if m.{{ GoName .Name }}Func == nil {
+		panic("MockQuerier.{{ GoName .Name }}Func is nil")
+	}
+	return m.{{ GoName .Name }}Func(ctx{{ ParamNames . }})
+}
+{{ end }}`
@@ -0,0 +1,216 @@
+package sqlproc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCodeGeneratorProcedureCall(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "transfer.sql", `-- name: Transfer :one
+CREATE OR REPLACE PROCEDURE transfer(p_from BIGINT, p_to BIGINT, INOUT p_amount NUMERIC, OUT p_balance NUMERIC)
+LANGUAGE plpgsql AS $$
+BEGIN
+	UPDATE accounts SET balance = balance - p_amount WHERE id = p_from;
+	UPDATE accounts SET balance = balance + p_amount WHERE id = p_to;
+	COMMIT;
+	SELECT balance INTO p_balance FROM accounts WHERE id = p_from;
+END;
+$$;
+
+-- name: Archive :exec
+CREATE PROCEDURE archive() LANGUAGE sql AS $$ DELETE FROM accounts WHERE closed $$;
+`)
+	procs, err := NewParser().ParseFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseFiles error: %v", err)
+	}
+	if !procs[0].IsProcedure() || len(procs[0].Returns) != 2 {
+		t.Fatalf("unexpected procedure metadata: %+v", procs[0])
+	}
+
+	src := generateSource(t, procs, "queries.go")
+	for _, want := range []string{
+		`"CALL transfer($1, $2, $3, NULL)"`,
+		"func (q *Queries) Transfer(ctx context.Context, from int64, to int64, amount float64) (TransferRow, error)",
+		"row.Scan(&dest.Amount, &dest.Balance)",
+		`"CALL archive()"`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in generated code:\n%s", want, src)
+		}
+	}
+}
+
+func TestProcedureValidateRejectsManyForCall(t *testing.T) {
+	proc := &Procedure{Name: "P", SQLName: "p", SQL: "CREATE PROCEDURE p()", Kind: ReturnMany, Routine: RoutineProcedure, Returns: []Column{{Name: "x", DBType: "int"}}}
+	if err := proc.Validate(); err == nil {
+		t.Fatal("expected :many procedures to be rejected")
+	}
+}
+
+func TestCodeGeneratorNullableColumns(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "user.sql", `-- name: UpdateBio :one
+-- param: user_id int
+-- param: bio text?
+-- returns: id int, email text null, bio text?, updated_at timestamptz null, name text not null
+CREATE FUNCTION update_bio(p_user_id INT, p_bio TEXT)
+RETURNS TABLE(id INT, email TEXT, bio TEXT, updated_at TIMESTAMPTZ, name TEXT) AS $$
+	UPDATE users SET bio = p_bio WHERE id = p_user_id RETURNING id, email, bio, updated_at, name;
+$$ LANGUAGE sql;`)
+	procs, err := NewParser().ParseFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseFiles error: %v", err)
+	}
+	if !procs[0].Params[1].Nullable || procs[0].Params[0].Nullable {
+		t.Fatalf("unexpected param nullability: %+v", procs[0].Params)
+	}
+
+	cases := []struct {
+		style   NullStyle
+		models  []string
+		queries []string
+	}{
+		{
+			style:   NullStylePointer,
+			models:  []string{"Email     *string", "UpdatedAt *time.Time", "Name      string"},
+			queries: []string{"bio *string"},
+		},
+		{
+			style:   NullStyleSQL,
+			models:  []string{`"database/sql"`, "Email     sql.NullString", "UpdatedAt sql.NullTime"},
+			queries: []string{`"database/sql"`, "bio sql.NullString"},
+		},
+		{
+			style:   NullStyleGeneric,
+			models:  []string{"Email     sql.Null[string]", "UpdatedAt sql.Null[time.Time]"},
+			queries: []string{"bio sql.Null[string]"},
+		},
+	}
+	for _, c := range cases {
+		out := t.TempDir()
+		cg := &CodeGenerator{OutputDir: out, PackageName: "gen", NullStyle: c.style}
+		if err := cg.Generate(procs); err != nil {
+			t.Fatalf("%s: Generate error: %v", c.style, err)
+		}
+		for file, wants := range map[string][]string{"models.go": c.models, "queries.go": c.queries} {
+			content, err := os.ReadFile(filepath.Join(out, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range wants {
+				if !strings.Contains(string(content), want) {
+					t.Fatalf("%s: expected %q in %s:\n%s", c.style, want, file, content)
+				}
+			}
+		}
+	}
+}
+
+func TestCodeGeneratorArrays(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "tags.sql", `-- name: TagPosts :many
+CREATE FUNCTION tag_posts(p_ids INT[], p_tags _text)
+RETURNS TABLE(id BIGINT, tags TEXT[], scores float8[]) AS $$
+	SELECT id, tags, scores FROM posts WHERE id = ANY(p_ids) AND tags && p_tags;
+$$ LANGUAGE sql;`)
+	procs, err := NewParser().ParseFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseFiles error: %v", err)
+	}
+
+	src := generateSource(t, procs, "queries.go")
+	for _, want := range []string{
+		`"github.com/lib/pq"`,
+		"ids []int32, tags []string",
+		"query, pq.Array(ids), pq.Array(tags))",
+		"rows.Scan(&dest.Id, pq.Array(&dest.Tags), pq.Array(&dest.Scores))",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in generated code:\n%s", want, src)
+		}
+	}
+	models := generateSource(t, procs, "models.go")
+	if !strings.Contains(models, "Scores []float64") {
+		t.Fatalf("expected slice field in models:\n%s", models)
+	}
+
+	// An adapter that cannot be imported is an error, not a silently
+	// unwrapped slice.
+	for _, adapter := range []string{"github.com/lib/pq", "github.com/acme/sql.Array"} {
+		cg := &CodeGenerator{OutputDir: t.TempDir(), PackageName: "gen", ArrayAdapter: adapter}
+		if err := cg.Generate(procs); err == nil || !strings.Contains(err.Error(), "array adapter") {
+			t.Fatalf("%s: expected array adapter error, got %v", adapter, err)
+		}
+	}
+}
+
+func TestCodeGeneratorParamsStruct(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "users.sql", `-- name: UpdateUser :exec
+-- option: params_struct
+CREATE PROCEDURE update_user(p_id BIGINT, p_display_name TEXT, p_tags TEXT[]) AS $$
+	UPDATE users SET display_name = p_display_name, tags = p_tags WHERE id = p_id;
+$$ LANGUAGE sql;
+
+-- name: DeleteUser :exec
+CREATE PROCEDURE delete_user(p_id BIGINT) AS $$
+	DELETE FROM users WHERE id = p_id;
+$$ LANGUAGE sql;`)
+	procs, err := NewParser().ParseFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseFiles error: %v", err)
+	}
+	if !procs[0].HasOption(OptionParamsStruct) || procs[1].HasOption(OptionParamsStruct) {
+		t.Fatalf("unexpected options %v, %v", procs[0].Options, procs[1].Options)
+	}
+
+	src := generateSource(t, procs, "queries.go")
+	for _, want := range []string{
+		"UpdateUser(ctx context.Context, arg UpdateUserParams) error",
+		"query, arg.Id, arg.DisplayName, pq.Array(arg.Tags))",
+		"DeleteUser(ctx context.Context, id int64) error",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in generated code:\n%s", want, src)
+		}
+	}
+	models := strings.Join(strings.Fields(generateSource(t, procs, "models.go")), " ")
+	if !strings.Contains(models, "type UpdateUserParams struct { Id int64 `json:\"id\"` DisplayName string `json:\"displayName\"`") {
+		t.Fatalf("expected params struct in models:\n%s", models)
+	}
+}
+
+func TestSQLTypeToGoArrays(t *testing.T) {
+	cases := map[string]string{
+		"int[]":          "[]int32",
+		"_int4":          "[]int32",
+		"bigint array":   "[]int64",
+		"text[][]":       "[][]string",
+		"varchar(20)[]":  "[]string",
+		"timestamptz[3]": "[]time.Time",
+		"int":            "int32",
+	}
+	for dbType, want := range cases {
+		if got := sqlTypeToGo(dbType); got != want {
+			t.Fatalf("sqlTypeToGo(%q) = %s, want %s", dbType, got, want)
+		}
+	}
+}
+
+func generateSource(t *testing.T, procs []*Procedure, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	cg := &CodeGenerator{OutputDir: dir, PackageName: "gen"}
+	if err := cg.Generate(procs); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(content)
+}
@@ -0,0 +1,172 @@
+package sqlproc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// depNamePattern matches an unquoted, optionally schema-qualified name.
+const depNamePattern = `[A-Za-z_][A-Za-z0-9_$]*(?:\.[A-Za-z_][A-Za-z0-9_$]*)?`
+
+var (
+	depCallPattern     = regexp.MustCompile(`(` + depNamePattern + `)\s*\(`)
+	depNameRegexp      = regexp.MustCompile(depNamePattern)
+	depCastPattern     = regexp.MustCompile(`::\s*(` + depNamePattern + `)`)
+	depDefTypePattern  = regexp.MustCompile(`(?i)\bcreate\s+type\s+(?:if\s+not\s+exists\s+)?(` + depNamePattern + `)`)
+	sqlLanguagePattern = regexp.MustCompile(`(?i)\blanguage\s+'?sql'?\b`)
+	depCreatePattern   = regexp.MustCompile(`(?i)\bcreate\s+(?:or\s+replace\s+)?(?:function|procedure)\s+[A-Za-z0-9_."$]+\s*\(`)
+)
+
+// SortProcedures orders procs so that each routine is created after the
+// routines it calls and the types it uses that other procedures create
+// (with CREATE TYPE in the same SQL block). Independent procedures keep their
+// original order. A dependency cycle is reported with the files involved.
+//
+// Calls are identifiers followed by "(". Types are identifiers in the routine
+// header (arguments and RETURNS) and casts ("::name") in the body. Only
+// LANGUAGE sql bodies are scanned, since PostgreSQL validates those at
+// creation time; other languages resolve names when the routine runs, so
+// they may call each other freely. Overloads sharing a SQL name never depend
+// on each other.
+func SortProcedures(procs []*Procedure) ([]*Procedure, error) {
+	deps := procedureDependencies(procs)
+	indegree := make([]int, len(procs))
+	dependents := make([][]int, len(procs))
+	for i, list := range deps {
+		indegree[i] = len(list)
+		for _, dep := range list {
+			dependents[dep] = append(dependents[dep], i)
+		}
+	}
+
+	sorted := make([]*Procedure, 0, len(procs))
+	done := make([]bool, len(procs))
+	for len(sorted) < len(procs) {
+		next := -1
+		for i := range procs {
+			if !done[i] && indegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, dependencyCycleError(procs, deps, done)
+		}
+		done[next] = true
+		sorted = append(sorted, procs[next])
+		for _, dependent := range dependents[next] {
+			indegree[dependent]--
+		}
+	}
+	return sorted, nil
+}
+
+// procedureDependencies returns, for each procedure, the indexes of the
+// other procedures it depends on.
+func procedureDependencies(procs []*Procedure) [][]int {
+	routines := make(map[string][]int)
+	types := make(map[string][]int)
+	for i, proc := range procs {
+		for _, key := range dependencyKeys(proc.SQLName) {
+			routines[key] = append(routines[key], i)
+		}
+		for _, match := range depDefTypePattern.FindAllStringSubmatch(stripSQLComments(proc.SQL), -1) {
+			for _, key := range dependencyKeys(match[1]) {
+				types[key] = append(types[key], i)
+			}
+		}
+	}
+
+	deps := make([][]int, len(procs))
+	for i, proc := range procs {
+		header, body := splitRoutineBody(proc.SQL)
+		header = depCreatePattern.ReplaceAllString(header, "(")
+		name := dependencyKeys(proc.SQLName)[0]
+		seen := make(map[int]bool)
+		add := func(targets []int) {
+			for _, target := range targets {
+				if target == i || seen[target] || slices.Contains(dependencyKeys(procs[target].SQLName), name) {
+					continue
+				}
+				seen[target] = true
+				deps[i] = append(deps[i], target)
+			}
+		}
+		if !sqlLanguagePattern.MatchString(header) {
+			body = ""
+		}
+		for _, text := range []string{header, body} {
+			for _, match := range depCallPattern.FindAllStringSubmatch(text, -1) {
+				add(routines[strings.ToLower(match[1])])
+			}
+		}
+		for _, ident := range depNameRegexp.FindAllString(header, -1) {
+			add(types[strings.ToLower(ident)])
+		}
+		for _, match := range depCastPattern.FindAllStringSubmatch(body, -1) {
+			add(types[strings.ToLower(match[1])])
+		}
+	}
+	return deps
+}
+
+// splitRoutineBody separates SQL into the text outside the dollar-quoted
+// routine body and the body itself, both with comments removed.
+func splitRoutineBody(sqlText string) (header, body string) {
+	body, ok := dollarQuotedBody(sqlText)
+	if !ok {
+		return stripSQLComments(sqlText), ""
+	}
+	header = stripSQLComments(strings.Replace(sqlText, body, " ", 1))
+	return header, stripSQLComments(body)
+}
+
+// dependencyKeys returns the lowercased names a reference to name may use:
+// unqualified names and names in the public schema match each other.
+func dependencyKeys(name string) []string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if bare, ok := strings.CutPrefix(name, "public."); ok {
+		return []string{name, bare}
+	}
+	if !strings.Contains(name, ".") {
+		return []string{name, "public." + name}
+	}
+	return []string{name}
+}
+
+// dependencyCycleError describes one cycle among the procedures that could
+// not be ordered.
+func dependencyCycleError(procs []*Procedure, deps [][]int, done []bool) error {
+	start := -1
+	for i := range procs {
+		if !done[i] {
+			start = i
+			break
+		}
+	}
+	// Every remaining procedure has an unresolved dependency, so following
+	// the first one repeatedly must revisit a procedure.
+	position := make(map[int]int)
+	var path []int
+	for current := start; ; {
+		if at, ok := position[current]; ok {
+			path = append(path[at:], current)
+			break
+		}
+		position[current] = len(path)
+		path = append(path, current)
+		for _, dep := range deps[current] {
+			if !done[dep] {
+				current = dep
+				break
+			}
+		}
+	}
+	steps := make([]string, 0, len(path))
+	for _, i := range path {
+		steps = append(steps, fmt.Sprintf("%s (%s:%d)", procs[i].SQLName, procs[i].File, procs[i].StartLine))
+	}
+	return fmt.Errorf("dependency cycle between procedures: %s", strings.Join(steps, " -> "))
+}
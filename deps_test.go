@@ -0,0 +1,79 @@
+package sqlproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortProcedures(t *testing.T) {
+	procs := []*Procedure{
+		{Name: "Report", SQLName: "report", File: "a_report.sql", SQL: `CREATE FUNCTION report() RETURNS numeric AS $$
+    SELECT (total_for(1)).amount; -- ignored_call() in a comment
+$$ LANGUAGE sql;`},
+		{Name: "TotalFor", SQLName: "public.total_for", File: "b_total.sql", SQL: `CREATE FUNCTION public.total_for(p_id integer) RETURNS summary AS $$
+    SELECT ROW(p_id, 0)::summary;
+$$ LANGUAGE sql;`},
+		{Name: "MakeSummary", SQLName: "make_summary", File: "c_types.sql", SQL: `CREATE TYPE summary AS (id integer, amount numeric);
+CREATE FUNCTION make_summary() RETURNS void AS $$ BEGIN END; $$ LANGUAGE plpgsql;`},
+		{Name: "Ping", SQLName: "ping", File: "d_ping.sql", SQL: `CREATE FUNCTION ping() RETURNS void AS $$
+BEGIN
+    PERFORM report();
+END;
+$$ LANGUAGE plpgsql;`},
+	}
+
+	sorted, err := SortProcedures(procs)
+	if err != nil {
+		t.Fatalf("SortProcedures error: %v", err)
+	}
+	var got []string
+	for _, proc := range sorted {
+		got = append(got, proc.Name)
+	}
+	if strings.Join(got, ",") != "MakeSummary,TotalFor,Report,Ping" {
+		t.Fatalf("unexpected order %v", got)
+	}
+}
+
+func TestSortProceduresCycle(t *testing.T) {
+	procs := []*Procedure{
+		{Name: "Even", SQLName: "is_even", File: "even.sql", StartLine: 2, SQL: "CREATE FUNCTION is_even(n int) RETURNS bool AS $$ SELECT n = 0 OR is_odd(n - 1) $$ LANGUAGE sql;"},
+		{Name: "Odd", SQLName: "is_odd", File: "odd.sql", StartLine: 3, SQL: "CREATE FUNCTION is_odd(n int) RETURNS bool AS $$ SELECT n <> 0 AND is_even(n - 1) $$ LANGUAGE sql;"},
+	}
+	_, err := SortProcedures(procs)
+	if err == nil || !strings.Contains(err.Error(), "is_even (even.sql:2) -> is_odd (odd.sql:3) -> is_even (even.sql:2)") {
+		t.Fatalf("expected cycle error naming both files, got %v", err)
+	}
+
+	// PL/pgSQL resolves calls at run time, so mutual recursion is allowed.
+	for _, proc := range procs {
+		proc.SQL = strings.Replace(proc.SQL, "LANGUAGE sql", "LANGUAGE plpgsql", 1)
+	}
+	if _, err := SortProcedures(procs); err != nil {
+		t.Fatalf("unexpected error for plpgsql routines: %v", err)
+	}
+}
+
+func TestSortProceduresOverloads(t *testing.T) {
+	procs := []*Procedure{
+		{Name: "GetUserByID", SQLName: "get_user", File: "a.sql", SQL: "CREATE OR REPLACE FUNCTION get_user(p_id integer) RETURNS text AS $$ SELECT get_user(p_id::text) $$ LANGUAGE sql;"},
+		{Name: "GetUserByEmail", SQLName: "public.get_user", File: "b.sql", SQL: "CREATE OR REPLACE FUNCTION public.get_user(p_email text) RETURNS text AS $$ SELECT p_email $$ LANGUAGE sql;"},
+		{Name: "Greet", SQLName: "greet", File: "c.sql", SQL: "CREATE FUNCTION greet() RETURNS text AS $$ SELECT get_user(1) $$ LANGUAGE sql;"},
+	}
+	for _, language := range []string{"sql", "plpgsql"} {
+		for _, proc := range procs {
+			proc.SQL = strings.Replace(proc.SQL, "LANGUAGE sql", "LANGUAGE "+language, 1)
+		}
+		sorted, err := SortProcedures(procs)
+		if err != nil {
+			t.Fatalf("%s: SortProcedures error: %v", language, err)
+		}
+		var got []string
+		for _, proc := range sorted {
+			got = append(got, proc.Name)
+		}
+		if strings.Join(got, ",") != "GetUserByID,GetUserByEmail,Greet" {
+			t.Fatalf("%s: unexpected order %v", language, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package sqlproc
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Enum describes a PostgreSQL enum type discovered via pg_enum.
+type Enum struct {
+	Schema string
+	Name   string
+	Labels []string
+}
+
+// GoName returns the Go type name generated for the enum.
+func (e *Enum) GoName() string {
+	return goStructName(e.Schema, e.Name)
+}
+
+func loadEnums(ctx context.Context, db *sql.DB, schemas []string) ([]*Enum, error) {
+	queryBuilder := strings.Builder{}
+	queryBuilder.WriteString(`
+SELECT n.nspname, t.typname, e.enumlabel
+FROM pg_type t
+JOIN pg_enum e ON e.enumtypid = t.oid
+JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')`)
+
+	var args []any
+	if len(schemas) > 0 {
+		queryBuilder.WriteString(" AND n.nspname IN (")
+		for i, schema := range schemas {
+			if i > 0 {
+				queryBuilder.WriteString(", ")
+			}
+			queryBuilder.WriteString(fmt.Sprintf("$%d", i+1))
+			args = append(args, schema)
+		}
+		queryBuilder.WriteString(")")
+	}
+	queryBuilder.WriteString(" ORDER BY n.nspname, t.typname, e.enumsortorder")
+
+	rows, err := db.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var enums []*Enum
+	for rows.Next() {
+		var schema, name, label string
+		if err := rows.Scan(&schema, &name, &label); err != nil {
+			return nil, err
+		}
+		if n := len(enums); n == 0 || enums[n-1].Schema != schema || enums[n-1].Name != name {
+			enums = append(enums, &Enum{Schema: schema, Name: name})
+		}
+		last := enums[len(enums)-1]
+		last.Labels = append(last.Labels, label)
+	}
+	return enums, rows.Err()
+}
+
+// enumTypeNames maps enum type names, bare and schema-qualified, to Go names.
+func enumTypeNames(enums []*Enum) map[string]string {
+	names := make(map[string]string, len(enums)*2)
+	for _, enum := range enums {
+		goName := enum.GoName()
+		names[strings.ToLower(enum.Schema+"."+enum.Name)] = goName
+		if _, taken := names[strings.ToLower(enum.Name)]; !taken || enum.Schema == "public" {
+			names[strings.ToLower(enum.Name)] = goName
+		}
+	}
+	return names
+}
+
+type enumTemplateData struct {
+	Name   string
+	Values []enumTemplateValue
+}
+
+type enumTemplateValue struct {
+	Const string
+	Label string
+}
+
+func buildEnumTemplateData(enums []*Enum) []enumTemplateData {
+	data := make([]enumTemplateData, 0, len(enums))
+	for _, enum := range enums {
+		item := enumTemplateData{Name: enum.GoName()}
+		seen := make(map[string]int)
+		for _, label := range enum.Labels {
+			name := item.Name + enumLabelName(label)
+			seen[name]++
+			if seen[name] > 1 {
+				name = fmt.Sprintf("%s%d", name, seen[name])
+			}
+			item.Values = append(item.Values, enumTemplateValue{Const: name, Label: label})
+		}
+		data = append(data, item)
+	}
+	return data
+}
+
+func enumLabelName(label string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r < 128 && (r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, label)
+	name := toGoName(strings.Trim(cleaned, "_"))
+	if name == "" {
+		return "Empty"
+	}
+	return name
+}
+
+// writeEnumsFile renders enums.go into dir and returns its path.
+func writeEnumsFile(dir, pkg string, enums []*Enum) (string, error) {
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("enums").Parse(enumsTemplate))
+	if err := tmpl.Execute(&buf, buildEnumTemplateData(enums)); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "enums.go")
+	contents := formatGoFile(pkg, []string{"database/sql/driver", "fmt"}, buf.Bytes())
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		return "", fmt.Errorf("write enums: %w", err)
+	}
+	return path, nil
+}
+
+const enumsTemplate = `{{ range . }}{{ $enum := . }}
+type {{ .Name }} string
+
+const (
+{{- range .Values }}
+	{{ .Const }} {{ $enum.Name }} = {{ printf "%q" .Label }}
+{{- end }}
+)
+
+func (e {{ .Name }}) Valid() bool {
+	switch e {
+	case {{ range $i, $v := .Values }}{{ if $i }}, {{ end }}{{ $v.Const }}{{ end }}:
+		return true
+	}
+	return false
+}
+
+func (e *{{ .Name }}) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		*e = {{ .Name }}(v)
+	case []byte:
+		*e = {{ .Name }}(v)
+	default:
+		return fmt.Errorf("unsupported scan type for {{ .Name }}: %T", src)
+	}
+	return nil
+}
+
+func (e {{ .Name }}) Value() (driver.Value, error) {
+	return string(e), nil
+}
+{{ end }}
+`
@@ -0,0 +1,88 @@
+package sqlproc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeMapperEnums(t *testing.T) {
+	enums := []*Enum{{Schema: "public", Name: "mood", Labels: []string{"happy", "sad"}}}
+	types := newTypeMapper(NullStylePointer, nil, enums)
+
+	cases := []struct {
+		dbType   string
+		nullable bool
+		want     string
+	}{
+		{"mood", false, "Mood"},
+		{"public.mood", false, "Mood"},
+		{"mood", true, "*Mood"},
+		{"_mood", false, "[]Mood"},
+		{"mood[]", false, "[]Mood"},
+		{"text", false, "string"},
+	}
+	for _, c := range cases {
+		if got := types.goType(c.dbType, c.nullable); got != c.want {
+			t.Fatalf("goType(%q, %v) = %q; want %q", c.dbType, c.nullable, got, c.want)
+		}
+	}
+}
+
+func TestWriteEnumsFile(t *testing.T) {
+	dir := t.TempDir()
+	enums := []*Enum{{Schema: "public", Name: "order_status", Labels: []string{"pending", "in-progress", "in progress", ""}}}
+	path, err := writeEnumsFile(dir, "gen", enums)
+	if err != nil {
+		t.Fatalf("writeEnumsFile error: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read enums: %v", err)
+	}
+	src := strings.Join(strings.Fields(string(content)), " ")
+	for _, want := range []string{
+		"type OrderStatus string",
+		`OrderStatusPending OrderStatus = "pending"`,
+		`OrderStatusInProgress OrderStatus = "in-progress"`,
+		`OrderStatusInProgress2 OrderStatus = "in progress"`,
+		`OrderStatusEmpty OrderStatus = ""`,
+		"func (e OrderStatus) Valid() bool",
+		"func (e *OrderStatus) Scan(src any) error",
+		"func (e OrderStatus) Value() (driver.Value, error)",
+		`"database/sql/driver"`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in enums.go:\n%s", want, src)
+		}
+	}
+}
+
+func TestGeneratorUsesEnums(t *testing.T) {
+	dir := t.TempDir()
+	cg := &CodeGenerator{
+		OutputDir:   dir,
+		PackageName: "gen",
+		Enums:       []*Enum{{Schema: "public", Name: "mood", Labels: []string{"happy", "sad"}}},
+	}
+	procs := []*Procedure{{
+		Name:    "SetMood",
+		SQLName: "set_mood",
+		Kind:    ReturnExec,
+		Params:  []Param{{Name: "mood", DBType: "mood"}},
+	}}
+	if err := cg.Generate(procs); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	queries, err := os.ReadFile(filepath.Join(dir, "queries.go"))
+	if err != nil {
+		t.Fatalf("read queries: %v", err)
+	}
+	if !strings.Contains(string(queries), "SetMood(ctx context.Context, mood Mood) error") {
+		t.Fatalf("expected enum-typed param, got:\n%s", queries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "enums.go")); err != nil {
+		t.Fatalf("expected enums.go: %v", err)
+	}
+}
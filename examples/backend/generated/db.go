@@ -22,3 +22,14 @@ func New(db DBTX) *Queries {
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{db: tx}
 }
+
+// Querier is implemented by *Queries and lists every generated method.
+type Querier interface {
+	CreateUser(ctx context.Context, name string, email string) (CreateUserRow, error)
+	DeleteUser(ctx context.Context, userId int32) error
+	GetUser(ctx context.Context, userId int32) (GetUserRow, error)
+	ListUsers(ctx context.Context) ([]ListUsersRow, error)
+	UpdateUser(ctx context.Context, userId int32, email string) (UpdateUserRow, error)
+}
+
+var _ Querier = (*Queries)(nil)
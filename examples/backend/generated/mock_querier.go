@@ -0,0 +1,50 @@
+package generated
+
+import "context"
+
+// MockQuerier is a Querier whose methods call the matching function fields.
+// Calling a method whose field is nil panics.
+type MockQuerier struct {
+	CreateUserFunc func(ctx context.Context, name string, email string) (CreateUserRow, error)
+	DeleteUserFunc func(ctx context.Context, userId int32) error
+	GetUserFunc    func(ctx context.Context, userId int32) (GetUserRow, error)
+	ListUsersFunc  func(ctx context.Context) ([]ListUsersRow, error)
+	UpdateUserFunc func(ctx context.Context, userId int32, email string) (UpdateUserRow, error)
+}
+
+var _ Querier = (*MockQuerier)(nil)
+
+func (m *MockQuerier) CreateUser(ctx context.Context, name string, email string) (CreateUserRow, error) {
+	if m.CreateUserFunc == nil {
+		panic("MockQuerier.CreateUserFunc is nil")
+	}
+	return m.CreateUserFunc(ctx, name, email)
+}
+
+func (m *MockQuerier) DeleteUser(ctx context.Context, userId int32) error {
+	if m.DeleteUserFunc == nil {
+		panic("MockQuerier.DeleteUserFunc is nil")
+	}
+	return m.DeleteUserFunc(ctx, userId)
+}
+
+func (m *MockQuerier) GetUser(ctx context.Context, userId int32) (GetUserRow, error) {
+	if m.GetUserFunc == nil {
+		panic("MockQuerier.GetUserFunc is nil")
+	}
+	return m.GetUserFunc(ctx, userId)
+}
+
+func (m *MockQuerier) ListUsers(ctx context.Context) ([]ListUsersRow, error) {
+	if m.ListUsersFunc == nil {
+		panic("MockQuerier.ListUsersFunc is nil")
+	}
+	return m.ListUsersFunc(ctx)
+}
+
+func (m *MockQuerier) UpdateUser(ctx context.Context, userId int32, email string) (UpdateUserRow, error) {
+	if m.UpdateUserFunc == nil {
+		panic("MockQuerier.UpdateUserFunc is nil")
+	}
+	return m.UpdateUserFunc(ctx, userId, email)
+}
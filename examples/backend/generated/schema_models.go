@@ -2,15 +2,29 @@ package generated
 
 import "time"
 
+// SqlprocSchemaMigrations is a row of the public.sqlproc_schema_migrations table.
+// Primary key: (version).
 type SqlprocSchemaMigrations struct {
-	AppliedAt time.Time `db:"applied_at" json:"appliedAt"`
-	Name      string    `db:"name" json:"name"`
-	Version   int32     `db:"version" json:"version"`
+	// Primary key.
+	Version int32  `db:"version" json:"version" sqlproc:"pk"`
+	Name    string `db:"name" json:"name"`
+	// Default: now().
+	AppliedAt   time.Time `db:"applied_at" json:"appliedAt" sqlproc:"default"`
+	DownSql     *string   `db:"down_sql" json:"downSql"`
+	Checksum    *string   `db:"checksum" json:"checksum"`
+	AppliedBy   *string   `db:"applied_by" json:"appliedBy"`
+	ExecutionMs *int32    `db:"execution_ms" json:"executionMs"`
 }
 
+// Users is a row of the public.users table.
+// Primary key: (id).
+// Unique users_email_key: (email).
 type Users struct {
-	CreatedAt time.Time `db:"created_at" json:"createdAt"`
-	Email     string    `db:"email" json:"email"`
-	Id        int32     `db:"id" json:"id"`
-	Name      string    `db:"name" json:"name"`
+	// Primary key. Default: nextval('users_id_seq'::regclass).
+	Id   int32  `db:"id" json:"id" sqlproc:"pk,default"`
+	Name string `db:"name" json:"name"`
+	// Unique.
+	Email string `db:"email" json:"email" sqlproc:"unique"`
+	// Default: now().
+	CreatedAt time.Time `db:"created_at" json:"createdAt" sqlproc:"default"`
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -14,6 +15,12 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// sqlFiles holds the stored procedures and schema migrations, so the binary
+// can migrate its database from any working directory.
+//
+//go:embed funcs/*.sql migrations/*.sql
+var sqlFiles embed.FS
+
 func main() {
 	dbURL := envOrDefault("DATABASE_URL", "postgres://bibek@localhost:5432/sqlproc?sslmode=disable")
 	db, err := sql.Open("postgres", dbURL)
@@ -27,12 +34,14 @@ func main() {
 		log.Fatalf("ping db: %v", err)
 	}
 
-	if err := migrateSchema(ctx, db); err != nil {
-		log.Fatalf("schema migrations: %v", err)
-	}
-
-	if err := migrateProcedures(ctx, db); err != nil {
-		log.Fatalf("migrate procedures: %v", err)
+	if _, err := sqlproc.Run(ctx, sqlproc.PipelineOptions{
+		FS:              sqlFiles,
+		SQLInputs:       []string{"funcs"},
+		MigrationInputs: []string{"migrations"},
+		DB:              db,
+		SkipGenerate:    true,
+	}); err != nil {
+		log.Fatalf("migrate: %v", err)
 	}
 
 	server := &Server{
@@ -49,7 +58,7 @@ func main() {
 
 type Server struct {
 	db      *sql.DB
-	queries *generated.Queries
+	queries generated.Querier
 }
 
 func (s *Server) routes() http.Handler {
@@ -158,31 +167,6 @@ func writeJSON(w http.ResponseWriter, v any, status int) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-func migrateSchema(ctx context.Context, db *sql.DB) error {
-	files, err := sqlproc.ResolveFiles([]string{"examples/backend/migrations"})
-	if err != nil {
-		return err
-	}
-	migrations, err := sqlproc.LoadSchemaMigrations(files)
-	if err != nil {
-		return err
-	}
-	return sqlproc.NewSchemaMigrator(db).Migrate(ctx, migrations)
-}
-
-func migrateProcedures(ctx context.Context, db *sql.DB) error {
-	files, err := sqlproc.ResolveFiles([]string{"examples/backend/funcs"})
-	if err != nil {
-		return err
-	}
-	parser := sqlproc.NewParser()
-	procs, err := parser.ParseFiles(files)
-	if err != nil {
-		return err
-	}
-	return sqlproc.NewMigrator(db).Migrate(ctx, procs)
-}
-
 func envOrDefault(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
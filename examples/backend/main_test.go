@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Bibek99/sqlproc/examples/backend/generated"
+)
+
+func TestGetUser(t *testing.T) {
+	server := &Server{queries: &generated.MockQuerier{
+		GetUserFunc: func(ctx context.Context, userId int32) (generated.GetUserRow, error) {
+			if userId != 7 {
+				return generated.GetUserRow{}, sql.ErrNoRows
+			}
+			return generated.GetUserRow{Id: 7, Name: "Ada", Email: "ada@example.com"}, nil
+		},
+	}}
+
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/7", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"email":"ada@example.com"`) {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/8", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
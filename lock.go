@@ -0,0 +1,96 @@
+package sqlproc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultMigrationLockKey is the advisory lock key used when
+// PipelineOptions.MigrationLockKey is zero. It spells "sqlproc" in ASCII.
+const DefaultMigrationLockKey int64 = 0x73716c70726f63
+
+// migrationLockPollInterval is how often a waiting instance retries the lock.
+var migrationLockPollInterval = 500 * time.Millisecond
+
+// WithMigrationLock runs fn while holding the advisory lock Run takes around
+// migrations, so commands such as Rollback, MigrateTo and Repair cannot race a
+// concurrent Run. A zero key selects DefaultMigrationLockKey and a positive
+// timeout bounds the wait. The SchemaMigrator passed to fn runs its
+// statements on the locked connection.
+func WithMigrationLock(ctx context.Context, db *sql.DB, key int64, timeout time.Duration, logger Logger, fn func(m *SchemaMigrator) error) (err error) {
+	if key == 0 {
+		key = DefaultMigrationLockKey
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	conn, release, err := acquireMigrationLock(ctx, db, key, timeout, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := release(); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+	return fn(&SchemaMigrator{db: conn, Logger: logger})
+}
+
+// acquireMigrationLock takes a session-level PostgreSQL advisory lock on a
+// dedicated connection, retrying until it is granted, timeout elapses (when
+// positive) or ctx is done. Migrations must run on the returned connection:
+// with a pool limited to one connection, any other query would wait for it
+// forever. The returned release func unlocks and returns the connection to
+// the pool.
+func acquireMigrationLock(ctx context.Context, db *sql.DB, key int64, timeout time.Duration, logger Logger) (*sql.Conn, func() error, error) {
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := db.Conn(waitCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acquire migration lock: %w", err)
+	}
+
+	logged := false
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(waitCtx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("acquire migration lock %d: %w", key, err)
+		}
+		if locked {
+			break
+		}
+		if !logged {
+			logger.Printf("waiting for migration lock %d held by another instance", key)
+			logged = true
+		}
+		select {
+		case <-waitCtx.Done():
+			conn.Close()
+			if ctx.Err() == nil {
+				return nil, nil, fmt.Errorf("acquire migration lock %d: timed out after %s", key, timeout)
+			}
+			return nil, nil, fmt.Errorf("acquire migration lock %d: %w", key, ctx.Err())
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+
+	release := func() error {
+		defer conn.Close()
+		// The caller's context may already be cancelled; unlock regardless so
+		// the lock is not held until the pooled connection is recycled.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			return fmt.Errorf("release migration lock %d: %w", key, err)
+		}
+		return nil
+	}
+	return conn, release, nil
+}
@@ -0,0 +1,100 @@
+package sqlproc
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAcquireMigrationLockWaits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	restore := migrationLockPollInterval
+	migrationLockPollInterval = time.Millisecond
+	defer func() { migrationLockPollInterval = restore }()
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	var logs strings.Builder
+	_, release, err := acquireMigrationLock(context.Background(), db, 42, time.Second, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatalf("acquireMigrationLock error: %v", err)
+	}
+	if err := release(); err != nil {
+		t.Fatalf("release error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "waiting for migration lock 42") {
+		t.Fatalf("expected wait message, got %q", logs.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestAcquireMigrationLockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	restore := migrationLockPollInterval
+	migrationLockPollInterval = time.Hour
+	defer func() { migrationLockPollInterval = restore }()
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	_, _, err = acquireMigrationLock(context.Background(), db, 42, 10*time.Millisecond, log.New(io.Discard, "", 0))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestWithMigrationLockUsesLockedConnection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(DefaultMigrationLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, name, COALESCE\(down_sql, ''\) FROM sqlproc_schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "down_sql"}).AddRow(1, "init", "DROP TABLE foo;"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DROP TABLE foo`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM sqlproc_schema_migrations`).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(DefaultMigrationLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = WithMigrationLock(ctx, db, 0, 0, log.New(io.Discard, "", 0), func(m *SchemaMigrator) error {
+		return m.Rollback(ctx, 1)
+	})
+	if err != nil {
+		t.Fatalf("WithMigrationLock error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
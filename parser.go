@@ -1,12 +1,12 @@
 package sqlproc
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -22,27 +22,98 @@ const (
 	ReturnExec ReturnKind = ":exec"
 )
 
+// RoutineKind distinguishes PostgreSQL functions from procedures.
+type RoutineKind string
+
+const (
+	// RoutineFunction is created with CREATE FUNCTION and invoked with SELECT.
+	RoutineFunction RoutineKind = "function"
+	// RoutineProcedure is created with CREATE PROCEDURE and invoked with CALL.
+	// Procedures may commit inside their bodies and return INOUT/OUT values
+	// as a single row.
+	RoutineProcedure RoutineKind = "procedure"
+)
+
+// ParamMode describes how an argument is passed to a routine.
+type ParamMode string
+
+const (
+	// ParamIn is an ordinary input argument.
+	ParamIn ParamMode = "IN"
+	// ParamOut is an output-only argument returned as a result column.
+	ParamOut ParamMode = "OUT"
+	// ParamInOut is both supplied by the caller and returned as a result column.
+	ParamInOut ParamMode = "INOUT"
+	// ParamVariadic is a trailing array argument.
+	ParamVariadic ParamMode = "VARIADIC"
+)
+
+// IsInput reports whether callers supply a value for the argument.
+func (m ParamMode) IsInput() bool {
+	return m != ParamOut
+}
+
+// IsOutput reports whether the argument is returned as a result column.
+func (m ParamMode) IsOutput() bool {
+	return m == ParamOut || m == ParamInOut
+}
+
 // Procedure represents a parsed stored procedure/function.
 type Procedure struct {
 	Name    string
 	SQLName string
 	File    string
 	SQL     string
-	Kind    ReturnKind
+	// StartLine and EndLine are the 1-based lines of SQL within File.
+	StartLine int
+	EndLine   int
+	Kind      ReturnKind
+	// Routine is RoutineFunction or RoutineProcedure. The zero value is a function.
+	Routine RoutineKind
 	Params  []Param
 	Returns []Column
+	// ReturnType names the table, view or composite type of RETURNS [SETOF]
+	// name when Returns must be resolved with ResolveReturnTypes.
+	ReturnType string
+	// Options are the generator options set with -- option: comments.
+	Options []string
+	// Doc documents the generated method. It is taken from the prose "--"
+	// comments above the SQL or, failing that, from a COMMENT ON FUNCTION or
+	// COMMENT ON PROCEDURE statement for the routine.
+	Doc string
+
+	// query replaces the generated SELECT or CALL for methods whose
+	// statement sqlproc writes itself, such as schema table queries.
+	query string
+	// refresh marks a Refresh<View> method, whose concurrently param picks
+	// REFRESH MATERIALIZED VIEW CONCURRENTLY instead of being bound.
+	refresh bool
+}
+
+// OptionParamsStruct makes the generated method take a <Name>Params struct
+// instead of one Go argument per parameter.
+const OptionParamsStruct = "params_struct"
+
+var knownOptions = map[string]bool{
+	OptionParamsStruct: true,
 }
 
 // Param describes a single procedure parameter.
 type Param struct {
 	Name   string
 	DBType string
+	// Mode is the argument mode. The zero value behaves like ParamIn.
+	Mode ParamMode
+	// Nullable marks parameters annotated with "null" or "?" that accept NULL.
+	Nullable bool
 }
 
 // Column describes a column returned by the procedure.
 type Column struct {
 	Name   string
 	DBType string
+	// Nullable marks columns annotated with "null" or "?" that may be NULL.
+	Nullable bool
 }
 
 // Parser parses SQL files containing stored procedures.
@@ -50,6 +121,7 @@ type Parser struct {
 	namePattern    *regexp.Regexp
 	paramPattern   *regexp.Regexp
 	returnsPattern *regexp.Regexp
+	optionPattern  *regexp.Regexp
 	funcPattern    *regexp.Regexp
 }
 
@@ -59,85 +131,241 @@ func NewParser() *Parser {
 		namePattern:    regexp.MustCompile(`--\s*name:\s*([A-Za-z0-9_]+)\s*(:(one|many|exec))`),
 		paramPattern:   regexp.MustCompile(`--\s*param:\s*([A-Za-z0-9_]+)\s+(.+)`),
 		returnsPattern: regexp.MustCompile(`--\s*returns:\s*(.+)`),
-		funcPattern:    regexp.MustCompile(`(?is)create\s+(or\s+replace\s+)?function\s+([A-Za-z0-9_\."]+)`),
+		optionPattern:  regexp.MustCompile(`--\s*option:\s*(.+)`),
+		funcPattern:    regexp.MustCompile(`(?is)create\s+(or\s+replace\s+)?(function|procedure)\s+([A-Za-z0-9_\."]+)`),
 	}
 }
 
-// ParseFiles parses a list of SQL files.
+// ParseFiles parses a list of SQL files, returning every procedure they define.
 func (p *Parser) ParseFiles(files []string) ([]*Procedure, error) {
+	return p.parseFiles(nil, files)
+}
+
+// ParseFilesFS is like ParseFiles but reads the files from fsys.
+func (p *Parser) ParseFilesFS(fsys fs.FS, files []string) ([]*Procedure, error) {
+	return p.parseFiles(fsys, files)
+}
+
+func (p *Parser) parseFiles(fsys fs.FS, files []string) ([]*Procedure, error) {
 	var procedures []*Procedure
+	seen := make(map[string]*Procedure)
 	for _, file := range files {
-		proc, err := p.ParseFile(file)
+		procs, err := p.parseFileAll(fsys, file)
 		if err != nil {
 			return nil, err
 		}
-		procedures = append(procedures, proc)
+		for _, proc := range procs {
+			if prev, ok := seen[proc.Name]; ok {
+				return nil, fmt.Errorf("duplicate procedure name %s (%s:%d and %s:%d)", proc.Name, prev.File, prev.StartLine, proc.File, proc.StartLine)
+			}
+			seen[proc.Name] = proc
+		}
+		procedures = append(procedures, procs...)
 	}
 	return procedures, nil
 }
 
-// ParseFile parses a single SQL file and extracts metadata plus SQL body.
+// ParseFile parses a SQL file that defines exactly one procedure.
 func (p *Parser) ParseFile(path string) (*Procedure, error) {
-	fd, err := os.Open(path)
+	return p.parseFile(nil, path)
+}
+
+// ParseFileFS is like ParseFile but reads the file from fsys.
+func (p *Parser) ParseFileFS(fsys fs.FS, path string) (*Procedure, error) {
+	return p.parseFile(fsys, path)
+}
+
+func (p *Parser) parseFile(fsys fs.FS, path string) (*Procedure, error) {
+	procs, err := p.parseFileAll(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(procs) != 1 {
+		return nil, fmt.Errorf("%s defines %d procedures; use ParseFileAll", path, len(procs))
+	}
+	return procs[0], nil
+}
+
+// ParseFileAll parses every procedure in a SQL file. Each "-- name:" header
+// starts a new procedure that runs until the next header; headers inside
+// dollar-quoted bodies are ignored.
+func (p *Parser) ParseFileAll(path string) ([]*Procedure, error) {
+	return p.parseFileAll(nil, path)
+}
+
+// ParseFileAllFS is like ParseFileAll but reads the file from fsys.
+func (p *Parser) ParseFileAllFS(fsys fs.FS, path string) ([]*Procedure, error) {
+	return p.parseFileAll(fsys, path)
+}
+
+func (p *Parser) parseFileAll(fsys fs.FS, path string) ([]*Procedure, error) {
+	content, err := readFile(fsys, path)
 	if err != nil {
 		return nil, fmt.Errorf("open SQL file: %w", err)
 	}
-	defer fd.Close()
+	return p.parseSQL(path, string(content))
+}
+
+func (p *Parser) parseSQL(path, content string) ([]*Procedure, error) {
+	var blocks [][]sqlLine
+	var current []sqlLine
+	hasHeader := false
+	for _, line := range scanSQLLines(content) {
+		if line.TopLevel && p.namePattern.MatchString(line.Text) {
+			if hasHeader {
+				// Prose comments directly above a header belong to it.
+				split := len(current)
+				for split > 0 && current[split-1].TopLevel && isCommentLine(current[split-1].Text) {
+					split--
+				}
+				blocks = append(blocks, current[:split])
+				current = append([]sqlLine(nil), current[split:]...)
+			}
+			hasHeader = true
+		}
+		current = append(current, line)
+	}
+	blocks = append(blocks, current)
+
+	procs := make([]*Procedure, 0, len(blocks))
+	for _, block := range blocks {
+		proc, err := p.parseBlock(path, block)
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
 
+// parseBlock parses the metadata comments and SQL statement of one procedure.
+func (p *Parser) parseBlock(path string, block []sqlLine) (*Procedure, error) {
 	proc := &Procedure{
 		File:    path,
 		Params:  make([]Param, 0),
 		Returns: make([]Column, 0),
 	}
 
-	scanner := bufio.NewScanner(fd)
-	var sqlLines []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
+	first, last := -1, -1
+	headerLine := 0
+	var doc []string
+	docBreak := false
+	for i, line := range block {
+		if !line.TopLevel {
+			last = i
+			continue
+		}
 
-		if matches := p.namePattern.FindStringSubmatch(line); matches != nil {
+		if matches := p.namePattern.FindStringSubmatch(line.Text); matches != nil {
 			proc.Name = matches[1]
 			proc.Kind = ReturnKind(matches[2])
+			headerLine = line.Number
 			continue
 		}
 
-		if matches := p.paramPattern.FindStringSubmatch(line); matches != nil {
+		if matches := p.paramPattern.FindStringSubmatch(line.Text); matches != nil {
+			dbType, nullable := splitNullability(matches[2])
 			proc.Params = append(proc.Params, Param{
-				Name:   matches[1],
-				DBType: normalizeType(matches[2]),
+				Name:     matches[1],
+				DBType:   dbType,
+				Mode:     ParamIn,
+				Nullable: nullable,
 			})
 			continue
 		}
 
-		if matches := p.returnsPattern.FindStringSubmatch(line); matches != nil {
+		if matches := p.returnsPattern.FindStringSubmatch(line.Text); matches != nil {
 			cols := p.parseColumns(matches[1])
 			proc.Returns = append(proc.Returns, cols...)
 			continue
 		}
 
-		if !strings.HasPrefix(trimmed, "--") {
-			sqlLines = append(sqlLines, line)
+		if matches := p.optionPattern.FindStringSubmatch(line.Text); matches != nil {
+			for _, opt := range strings.FieldsFunc(matches[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+				proc.Options = append(proc.Options, strings.ToLower(opt))
+			}
+			continue
 		}
+
+		if strings.TrimSpace(line.Text) == "" {
+			// A blank line ends a comment paragraph; only the last one
+			// before the SQL documents the procedure, not a file banner.
+			docBreak = len(doc) > 0
+			continue
+		}
+		if isCommentLine(line.Text) {
+			if first < 0 {
+				if docBreak {
+					doc, docBreak = nil, false
+				}
+				doc = append(doc, commentText(line.Text))
+			}
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan SQL file: %w", err)
+	if first >= 0 {
+		lines := make([]string, 0, last-first+1)
+		for _, line := range block[first : last+1] {
+			lines = append(lines, line.Text)
+		}
+		proc.SQL = strings.TrimSpace(strings.Join(lines, "\n"))
+		proc.StartLine = block[first].Number
+		proc.EndLine = block[last].Number
+	} else {
+		proc.StartLine = headerLine
+		proc.EndLine = headerLine
 	}
 
-	proc.SQL = strings.TrimSpace(strings.Join(sqlLines, "\n"))
-	proc.SQLName = p.extractSQLName(proc.SQL)
+	header := stripSQLComments(proc.SQL)
+	proc.SQLName, proc.Routine = p.extractSQLName(header)
 	if proc.SQLName == "" {
 		proc.SQLName = proc.Name
 	}
+	proc.Doc = strings.TrimSpace(strings.Join(doc, "\n"))
+	if proc.Doc == "" {
+		proc.Doc = routineComment(header, proc.SQLName)
+	}
+	if err := p.applySignature(proc, header); err != nil {
+		return nil, fmt.Errorf("invalid procedure %s:%d: %w", path, proc.StartLine, err)
+	}
 
 	if err := proc.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid procedure %s: %w", path, err)
+		return nil, fmt.Errorf("invalid procedure %s:%d: %w", path, proc.StartLine, err)
 	}
 
 	return proc, nil
 }
 
+// applySignature fills params and return columns from the CREATE FUNCTION
+// header, using -- param and -- returns comments as overrides.
+func (p *Parser) applySignature(proc *Procedure, header string) error {
+	sig := p.parseSignature(header)
+	if sig == nil {
+		return nil
+	}
+	params, err := mergeParams(proc.Params, sig.params())
+	if err != nil {
+		return err
+	}
+	proc.Params = params
+
+	inferred, known := sig.returns()
+	returns, err := mergeReturns(proc.Returns, inferred, known)
+	if err != nil {
+		return err
+	}
+	proc.Returns = returns
+	if !known && len(returns) == 0 && proc.Kind != ReturnExec && !isPseudoType(sig.Type) {
+		proc.ReturnType = sig.Type
+	}
+	return nil
+}
+
 func (p *Parser) parseColumns(def string) []Column {
 	var columns []Column
 	for _, part := range strings.Split(def, ",") {
@@ -150,15 +378,24 @@ func (p *Parser) parseColumns(def string) []Column {
 			continue
 		}
 		name := segments[0]
-		dbType := normalizeType(strings.Join(segments[1:], " "))
-		columns = append(columns, Column{Name: name, DBType: dbType})
+		dbType, nullable := splitNullability(strings.Join(segments[1:], " "))
+		columns = append(columns, Column{Name: name, DBType: dbType, Nullable: nullable})
 	}
 	return columns
 }
 
 // CollectSQLFiles walks a directory and returns all .sql files.
 func CollectSQLFiles(root string) ([]string, error) {
-	info, err := os.Stat(root)
+	return collectSQLFiles(nil, root)
+}
+
+// CollectSQLFilesFS is like CollectSQLFiles but walks root within fsys.
+func CollectSQLFilesFS(fsys fs.FS, root string) ([]string, error) {
+	return collectSQLFiles(fsys, root)
+}
+
+func collectSQLFiles(fsys fs.FS, root string) ([]string, error) {
+	info, err := statFile(fsys, root)
 	if err != nil {
 		return nil, fmt.Errorf("stat path: %w", err)
 	}
@@ -168,38 +405,44 @@ func CollectSQLFiles(root string) ([]string, error) {
 	}
 
 	var files []string
-	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	walk := func(path string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if entry.IsDir() {
 			return nil
 		}
 		if strings.EqualFold(filepath.Ext(path), ".sql") {
 			files = append(files, path)
 		}
 		return nil
-	})
+	}
+	if fsys == nil {
+		err = filepath.WalkDir(root, walk)
+	} else {
+		err = fs.WalkDir(fsys, root, walk)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("walk sql dir: %w", err)
 	}
 	return files, nil
 }
 
-func (p *Parser) extractSQLName(sql string) string {
+func (p *Parser) extractSQLName(sql string) (string, RoutineKind) {
 	if sql == "" || p.funcPattern == nil {
-		return ""
+		return "", RoutineFunction
 	}
 	match := p.funcPattern.FindStringSubmatch(sql)
-	if len(match) < 3 {
-		return ""
+	if len(match) < 4 {
+		return "", RoutineFunction
 	}
-	name := strings.TrimSpace(match[2])
+	routine := RoutineKind(strings.ToLower(match[2]))
+	name := strings.TrimSpace(match[3])
 	name = strings.TrimSuffix(name, "(")
 	if strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) && len(name) >= 2 {
 		name = strings.Trim(name, `"`)
 	}
-	return name
+	return name, routine
 }
 
 // Validate ensures required metadata is present.
@@ -215,15 +458,73 @@ func (p *Procedure) Validate() error {
 	if p.SQLName == "" {
 		return errors.New("unable to determine SQL function name")
 	}
-	if p.Kind != ReturnExec && len(p.Returns) == 0 {
+	if p.IsProcedure() && p.Kind == ReturnMany {
+		return errors.New("procedures return at most one row of INOUT/OUT values; use :one or :exec")
+	}
+	if p.Kind != ReturnExec && len(p.Returns) == 0 && p.ReturnType == "" {
 		return errors.New("returning procedure must declare -- returns columns")
 	}
+	for _, opt := range p.Options {
+		if !knownOptions[opt] {
+			return fmt.Errorf("unknown -- option %q", opt)
+		}
+	}
 	if p.SQL == "" {
 		return errors.New("procedure SQL body is empty")
 	}
 	return nil
 }
 
+// IsProcedure reports whether the routine is a CREATE PROCEDURE invoked with CALL.
+func (p *Procedure) IsProcedure() bool {
+	return p.Routine == RoutineProcedure
+}
+
+// HasOption reports whether the procedure sets the given -- option.
+func (p *Procedure) HasOption(name string) bool {
+	for _, opt := range p.Options {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InputParams returns the parameters callers supply, skipping OUT arguments.
+func (p *Procedure) InputParams() []Param {
+	inputs := make([]Param, 0, len(p.Params))
+	for _, param := range p.Params {
+		if param.Mode.IsInput() {
+			inputs = append(inputs, param)
+		}
+	}
+	return inputs
+}
+
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "--")
+}
+
+// commentText returns the text of a "--" comment line without the marker.
+func commentText(line string) string {
+	text := strings.TrimPrefix(strings.TrimSpace(line), "--")
+	return strings.TrimRight(strings.TrimPrefix(text, " "), " \t")
+}
+
+var routineCommentPattern = regexp.MustCompile(`(?is)\bcomment\s+on\s+(?:function|procedure|routine)\s+([A-Za-z0-9_\."]+)\s*(?:\([^)]*\))?\s+is\s+'((?:[^']|'')*)'`)
+
+// routineComment returns the text of a COMMENT ON statement in sqlText for
+// the routine named sqlName, or "".
+func routineComment(sqlText, sqlName string) string {
+	for _, match := range routineCommentPattern.FindAllStringSubmatch(sqlText, -1) {
+		name := strings.ReplaceAll(match[1], `"`, "")
+		if slices.Contains(dependencyKeys(name), strings.ToLower(sqlName)) {
+			return strings.TrimSpace(strings.ReplaceAll(match[2], "''", "'"))
+		}
+	}
+	return ""
+}
+
 func normalizeType(dbType string) string {
 	return strings.TrimSpace(strings.ToLower(dbType))
 }
@@ -1,8 +1,10 @@
 package sqlproc
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -49,6 +51,235 @@ $$ LANGUAGE plpgsql;
 	}
 }
 
+func TestParserInfersSignature(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "proc.sql")
+	sql := `-- name: TopCustomers :many
+
+CREATE OR REPLACE FUNCTION top_customers(p_region TEXT, IN p_limit INT DEFAULT 10, OUT customer_id BIGINT, INOUT total NUMERIC(12, 2))
+RETURNS SETOF record AS $$
+	SELECT id, sum(amount) FROM orders WHERE region = p_region GROUP BY id LIMIT p_limit;
+$$ LANGUAGE sql;
+`
+	if err := os.WriteFile(file, []byte(sql), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	proc, err := NewParser().ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	wantParams := []Param{
+		{Name: "region", DBType: "text", Mode: ParamIn},
+		{Name: "limit", DBType: "int", Mode: ParamIn},
+		{Name: "customer_id", DBType: "bigint", Mode: ParamOut},
+		{Name: "total", DBType: "numeric(12, 2)", Mode: ParamInOut},
+	}
+	if len(proc.Params) != len(wantParams) {
+		t.Fatalf("unexpected params: %+v", proc.Params)
+	}
+	for i, want := range wantParams {
+		if proc.Params[i] != want {
+			t.Fatalf("param %d = %+v, want %+v", i, proc.Params[i], want)
+		}
+	}
+	if got := len(proc.InputParams()); got != 3 {
+		t.Fatalf("expected 3 input params, got %d", got)
+	}
+	if len(proc.Returns) != 2 || proc.Returns[0].Name != "customer_id" || proc.Returns[1].Name != "total" {
+		t.Fatalf("unexpected returns: %+v", proc.Returns)
+	}
+}
+
+func TestParserStripsPrefixesFromOutColumns(t *testing.T) {
+	file := writeTestFile(t, t.TempDir(), "totals.sql", `-- name: OrderTotals :one
+CREATE OR REPLACE FUNCTION order_totals(p_id BIGINT, OUT p_total NUMERIC, INOUT _count INT)
+AS $$
+	SELECT sum(amount), count(*)::int FROM orders WHERE customer_id = p_id;
+$$ LANGUAGE sql;
+`)
+	proc, err := NewParser().ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	want := []Column{{Name: "total", DBType: "numeric"}, {Name: "count", DBType: "int"}}
+	if len(proc.Returns) != len(want) {
+		t.Fatalf("unexpected returns: %+v", proc.Returns)
+	}
+	for i := range want {
+		if proc.Returns[i] != want[i] {
+			t.Fatalf("return %d = %+v, want %+v", i, proc.Returns[i], want[i])
+		}
+	}
+}
+
+func TestParserInfersScalarAndVoidReturns(t *testing.T) {
+	tmp := t.TempDir()
+	cases := []struct {
+		sql     string
+		returns []Column
+	}{
+		{
+			sql:     "-- name: CountUsers :one\nCREATE FUNCTION public.count_users() RETURNS bigint LANGUAGE sql AS $$ SELECT count(*) FROM users $$;",
+			returns: []Column{{Name: "count_users", DBType: "bigint"}},
+		},
+		{
+			sql:     "-- name: Touch :exec\nCREATE FUNCTION touch(INT) RETURNS void AS $$ BEGIN END; $$ LANGUAGE plpgsql;",
+			returns: []Column{},
+		},
+	}
+	for i, c := range cases {
+		file := filepath.Join(tmp, fmt.Sprintf("proc%d.sql", i))
+		if err := os.WriteFile(file, []byte(c.sql), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		proc, err := NewParser().ParseFile(file)
+		if err != nil {
+			t.Fatalf("ParseFile error: %v", err)
+		}
+		if len(proc.Returns) != len(c.returns) {
+			t.Fatalf("case %d: unexpected returns %+v", i, proc.Returns)
+		}
+		for j, col := range c.returns {
+			if proc.Returns[j] != col {
+				t.Fatalf("case %d: return %d = %+v, want %+v", i, j, proc.Returns[j], col)
+			}
+		}
+	}
+}
+
+func TestParserSignatureMismatch(t *testing.T) {
+	tmp := t.TempDir()
+	cases := map[string]string{
+		"param count": `-- name: GetUser :one
+-- param: user_id int
+-- param: email text
+CREATE FUNCTION get_user(p_user_id INT) RETURNS TABLE(id INT) AS $$ SELECT 1 $$ LANGUAGE sql;`,
+		"param type": `-- name: GetUser :one
+-- param: user_id text
+CREATE FUNCTION get_user(p_user_id INT) RETURNS TABLE(id INT) AS $$ SELECT 1 $$ LANGUAGE sql;`,
+		"return type": `-- name: GetUser :one
+-- returns: id text
+CREATE FUNCTION get_user(p_user_id INT) RETURNS TABLE(id INTEGER) AS $$ SELECT 1 $$ LANGUAGE sql;`,
+	}
+	for name, sql := range cases {
+		file := filepath.Join(tmp, strings.ReplaceAll(name, " ", "_")+".sql")
+		if err := os.WriteFile(file, []byte(sql), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewParser().ParseFile(file); err == nil {
+			t.Fatalf("%s: expected mismatch error", name)
+		}
+	}
+}
+
+func TestParserParseFileAll(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "billing.sql")
+	sql := `-- name: BillingTotal :one
+CREATE OR REPLACE FUNCTION billing_total(p_account_id BIGINT)
+RETURNS numeric AS $body$
+BEGIN
+	-- name: NotAHeader :exec
+	RETURN (SELECT sum(amount) FROM invoices WHERE account_id = p_account_id);
+END;
+$body$ LANGUAGE plpgsql;
+
+-- name: BillingReset :exec
+CREATE OR REPLACE FUNCTION billing_reset(p_account_id BIGINT)
+RETURNS void AS $$
+	DELETE FROM invoices WHERE account_id = p_account_id;
+$$ LANGUAGE sql;
+`
+	if err := os.WriteFile(file, []byte(sql), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser := NewParser()
+	procs, err := parser.ParseFileAll(file)
+	if err != nil {
+		t.Fatalf("ParseFileAll error: %v", err)
+	}
+	if len(procs) != 2 {
+		t.Fatalf("expected 2 procedures, got %d", len(procs))
+	}
+	if procs[0].Name != "BillingTotal" || procs[0].StartLine != 2 || procs[0].EndLine != 8 {
+		t.Fatalf("unexpected first procedure: %s lines %d-%d", procs[0].Name, procs[0].StartLine, procs[0].EndLine)
+	}
+	if !strings.Contains(procs[0].SQL, "-- name: NotAHeader") {
+		t.Fatalf("comment inside dollar-quoted body should be preserved: %s", procs[0].SQL)
+	}
+	if procs[1].Name != "BillingReset" || procs[1].SQLName != "billing_reset" || procs[1].StartLine != 11 {
+		t.Fatalf("unexpected second procedure: %+v", procs[1])
+	}
+	if _, err := parser.ParseFile(file); err == nil {
+		t.Fatal("ParseFile should reject files with several procedures")
+	}
+	if _, err := parser.ParseFiles([]string{file, file}); err == nil {
+		t.Fatal("ParseFiles should reject duplicate procedure names")
+	}
+}
+
+func TestParserProcedureDocs(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "accounts.sql", `-- Close marks an account as closed.
+--
+-- Closed accounts keep their invoices.
+-- name: CloseAccount :exec
+-- param: account_id bigint
+CREATE FUNCTION close_account(p_account_id BIGINT) RETURNS void AS $$
+	-- not documentation
+	UPDATE accounts SET closed = true WHERE id = p_account_id;
+$$ LANGUAGE sql;
+
+-- name: AccountBalance :one
+-- returns: balance numeric
+CREATE FUNCTION public.account_balance(p_account_id BIGINT) RETURNS numeric AS $$
+	SELECT sum(amount) FROM invoices WHERE account_id = p_account_id;
+$$ LANGUAGE sql;
+COMMENT ON FUNCTION account_balance(BIGINT) IS 'Sums the account''s invoices.';
+
+-- name: Ping :exec
+CREATE FUNCTION ping() RETURNS void AS $$ SELECT 1; $$ LANGUAGE sql;
+COMMENT ON FUNCTION other() IS 'Not about ping.';
+`)
+	procs, err := NewParser().ParseFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseFiles error: %v", err)
+	}
+	want := []string{
+		"Close marks an account as closed.\n\nClosed accounts keep their invoices.",
+		"Sums the account's invoices.",
+		"",
+	}
+	for i, proc := range procs {
+		if proc.Doc != want[i] {
+			t.Fatalf("%s.Doc = %q, want %q", proc.Name, proc.Doc, want[i])
+		}
+	}
+
+	src := generateSource(t, procs, "queries.go")
+	for _, doc := range []string{
+		"// Close marks an account as closed.\n//\n// Closed accounts keep their invoices.\nfunc (q *Queries) CloseAccount(",
+		"// Sums the account's invoices.\nfunc (q *Queries) AccountBalance(",
+		"}\n\nfunc (q *Queries) Ping(",
+	} {
+		if !strings.Contains(src, doc) {
+			t.Fatalf("expected %q in generated code:\n%s", doc, src)
+		}
+	}
+}
+
+func TestParserRejectsUnknownOption(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "ping.sql", `-- name: Ping :exec
+-- option: params_structs
+CREATE FUNCTION ping() RETURNS void AS $$ SELECT 1; $$ LANGUAGE sql;`)
+	if _, err := NewParser().ParseFiles([]string{file}); err == nil || !strings.Contains(err.Error(), "unknown -- option") {
+		t.Fatalf("expected unknown option error, got %v", err)
+	}
+}
+
 func TestResolveFiles(t *testing.T) {
 	tmp := t.TempDir()
 	dir := filepath.Join(tmp, "sql")
@@ -73,3 +304,52 @@ func TestResolveFiles(t *testing.T) {
 		t.Fatalf("expected %d files, got %d", len(files), len(resolved))
 	}
 }
+
+func TestParserUnnamedParenthesisedArgs(t *testing.T) {
+	file := writeTestFile(t, t.TempDir(), "round2.sql", `-- name: Round2 :one
+CREATE OR REPLACE FUNCTION round2(numeric(12, 2), varchar(20), IN numeric (5,1), p_scale int)
+RETURNS numeric AS $$
+	SELECT round($1, p_scale);
+$$ LANGUAGE sql;
+`)
+	proc, err := NewParser().ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	want := []Param{
+		{Name: "arg1", DBType: "numeric(12, 2)", Mode: ParamIn},
+		{Name: "arg2", DBType: "varchar(20)", Mode: ParamIn},
+		{Name: "arg3", DBType: "numeric(5,1)", Mode: ParamIn},
+		{Name: "scale", DBType: "int", Mode: ParamIn},
+	}
+	if len(proc.Params) != len(want) {
+		t.Fatalf("unexpected params: %+v", proc.Params)
+	}
+	for i := range want {
+		if proc.Params[i] != want[i] {
+			t.Fatalf("param %d = %+v, want %+v", i, proc.Params[i], want[i])
+		}
+	}
+	if got, want := proc.Signature(), "round2(numeric(12, 2), varchar(20), numeric(5,1), int)"; got != want {
+		t.Fatalf("Signature() = %q, want %q", got, want)
+	}
+}
+
+func TestParserDocSkipsFileBanner(t *testing.T) {
+	file := writeTestFile(t, t.TempDir(), "users.sql", `-- Copyright 2024 Example Corp.
+-- Licensed under the MIT license.
+
+-- GetUser fetches a user by id.
+-- name: GetUser :one
+CREATE FUNCTION get_user(p_id BIGINT) RETURNS TABLE(id BIGINT) AS $$
+	SELECT id FROM users WHERE id = p_id;
+$$ LANGUAGE sql;
+`)
+	proc, err := NewParser().ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	if want := "GetUser fetches a user by id."; proc.Doc != want {
+		t.Fatalf("Doc = %q, want %q", proc.Doc, want)
+	}
+}
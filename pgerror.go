@@ -0,0 +1,43 @@
+package sqlproc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// locateError prefixes err with file and, when the driver reports the
+// error position within sqlText, the matching line of file. startLine is
+// the line of file on which sqlText begins.
+func locateError(err error, file, sqlText string, startLine int) error {
+	if line, ok := errorLine(err, sqlText); ok && startLine > 0 {
+		return fmt.Errorf("%s:%d: %w", file, startLine+line-1, err)
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// errorLine converts the 1-based character position PostgreSQL reports for
+// a syntax or semantic error into a 1-based line of sqlText.
+func errorLine(err error, sqlText string) (int, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Position == "" {
+		return 0, false
+	}
+	position, convErr := strconv.Atoi(pqErr.Position)
+	if convErr != nil || position <= 0 {
+		return 0, false
+	}
+	line := 1
+	for _, r := range sqlText {
+		position--
+		if position == 0 {
+			return line, true
+		}
+		if r == '\n' {
+			line++
+		}
+	}
+	return 0, false
+}
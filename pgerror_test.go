@@ -0,0 +1,19 @@
+package sqlproc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestLocateError(t *testing.T) {
+	sqlText := "CREATE FUNCTION f()\nRETURNS int AS $$\n  SELEC 1;\n$$ LANGUAGE sql;"
+	err := &pq.Error{Message: `syntax error at or near "SELEC"`, Position: "41"}
+	if got := locateError(err, "funcs/f.sql", sqlText, 10).Error(); got != `funcs/f.sql:12: pq: syntax error at or near "SELEC"` {
+		t.Fatalf("locateError = %q", got)
+	}
+	if got := locateError(errors.New("boom"), "funcs/f.sql", sqlText, 10).Error(); got != "funcs/f.sql: boom" {
+		t.Fatalf("locateError without position = %q", got)
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
 )
 
 // Logger is a minimal logging interface used by the orchestration pipeline.
@@ -21,6 +24,10 @@ type PipelineOptions struct {
 	SQLInputs []string
 	// MigrationInputs are directories/files with schema migrations. Optional.
 	MigrationInputs []string
+	// FS, when set, is the filesystem SQLInputs and MigrationInputs are read
+	// from, e.g. an embed.FS. Inputs are then slash-separated paths within it.
+	// Generated code is still written to the OS filesystem.
+	FS fs.FS
 	// OutputDir is where generated Go files are written. Defaults to ./generated.
 	OutputDir string
 	// PackageName overrides the generated Go package name. Defaults to "generated".
@@ -43,6 +50,36 @@ type PipelineOptions struct {
 	Logger Logger
 	// SchemaModels controls schema-introspection-based model generation.
 	SchemaModels *SchemaModelOptions
+	// DriftPolicy selects how applied schema migrations whose files changed
+	// are handled: DriftFail (default), DriftWarn or DriftIgnore.
+	DriftPolicy DriftPolicy
+	// SkipMigrationLock disables the advisory lock taken around migrations.
+	SkipMigrationLock bool
+	// MigrationLockKey is the PostgreSQL advisory lock key held while
+	// migrating. Defaults to DefaultMigrationLockKey.
+	MigrationLockKey int64
+	// MigrationLockTimeout bounds how long Run waits for another instance to
+	// finish migrating. Zero waits until ctx is done.
+	MigrationLockTimeout time.Duration
+	// ForceProcedures re-applies procedures whose definition is unchanged.
+	ForceProcedures bool
+	// PruneProcedures drops routines previously applied by sqlproc that are
+	// no longer in SQLInputs.
+	PruneProcedures bool
+	// TransactionScope selects whether procedures, or procedures and schema
+	// migrations, are applied all-or-nothing. Defaults to
+	// TransactionPerMigration.
+	TransactionScope TransactionScope
+	// DropCascade lets procedure migrations drop routines that must be
+	// recreated or pruned together with the objects depending on them.
+	DropCascade bool
+	// DropUntrackedOverloads lets procedure migrations drop overloads of an
+	// applied routine that sqlproc did not create. By default they are
+	// reported as warnings.
+	DropUntrackedOverloads bool
+	// DryRun reports pending schema migrations and changed procedures in
+	// PipelineResult.Plan without migrating or generating code.
+	DryRun bool
 }
 
 // PipelineResult captures the work performed by Run.
@@ -53,6 +90,8 @@ type PipelineResult struct {
 	GeneratedFiles   []string
 	SchemaTables     []*Table
 	SchemaFiles      []string
+	// Plan is set for dry runs.
+	Plan *MigrationPlan
 }
 
 // Run executes the configured pipeline: resolve -> parse -> migrate -> generate.
@@ -76,13 +115,13 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 
 	var procs []*Procedure
 	if len(opts.SQLInputs) > 0 {
-		sqlFiles, err := ResolveFiles(opts.SQLInputs)
+		sqlFiles, err := resolveFiles(opts.FS, opts.SQLInputs)
 		if err != nil {
 			return nil, fmt.Errorf("resolve SQL inputs: %w", err)
 		}
 		logWriter.Printf("resolved %d SQL file(s)", len(sqlFiles))
 
-		procs, err = parser.ParseFiles(sqlFiles)
+		procs, err = parser.parseFiles(opts.FS, sqlFiles)
 		if err != nil {
 			return nil, fmt.Errorf("parse SQL files: %w", err)
 		}
@@ -92,11 +131,11 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 
 	var schemaMigrations []*SchemaMigration
 	if len(opts.MigrationInputs) > 0 {
-		migFiles, err := ResolveFiles(opts.MigrationInputs)
+		migFiles, err := resolveFiles(opts.FS, opts.MigrationInputs)
 		if err != nil {
 			return nil, fmt.Errorf("resolve migration inputs: %w", err)
 		}
-		schemaMigrations, err = LoadSchemaMigrations(migFiles)
+		schemaMigrations, err = loadSchemaMigrations(opts.FS, migFiles)
 		if err != nil {
 			return nil, fmt.Errorf("load migrations: %w", err)
 		}
@@ -111,6 +150,28 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 		defer cleanup()
 	}
 
+	if opts.DryRun {
+		if db == nil {
+			return nil, errors.New("sqlproc: DB or DBURL must be provided for a dry run")
+		}
+		procMigrator := &Migrator{
+			db:                     db,
+			Force:                  opts.ForceProcedures,
+			Prune:                  opts.PruneProcedures,
+			DropUntrackedOverloads: opts.DropUntrackedOverloads,
+		}
+		plan, err := planMigrations(ctx, db, schemaMigrations, procs, procMigrator)
+		if err != nil {
+			return nil, err
+		}
+		logPlan(logWriter, plan)
+		return &PipelineResult{
+			Procedures:       procs,
+			SchemaMigrations: schemaMigrations,
+			Plan:             plan,
+		}, nil
+	}
+
 	if !opts.SkipMigrate {
 		if db == nil {
 			return nil, errors.New("sqlproc: DB or DBURL must be provided when migrations are enabled")
@@ -121,7 +182,34 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 		if len(procs) > 0 {
 			logWriter.Printf("applying %d stored procedure(s)", len(procs))
 		}
-		if err := runMigrations(ctx, db, schemaMigrations, procs); err != nil {
+		if err := runMigrations(ctx, db, opts, logWriter, schemaMigrations, procs); err != nil {
+			return nil, err
+		}
+	}
+
+	generateProcs := !opts.SkipGenerate && len(procs) > 0
+	var enums []*Enum
+	if db != nil && (generateProcs || opts.SchemaModels != nil) {
+		var schemas []string
+		if !generateProcs {
+			schemas = opts.SchemaModels.Schemas
+		}
+		enums, err = loadEnums(ctx, db, schemas)
+		if err != nil {
+			return nil, fmt.Errorf("load enums: %w", err)
+		}
+	}
+
+	if names := returnTypeNames(procs); generateProcs && len(names) > 0 {
+		rowTypes := RowTypesFromMigrations(schemaMigrations)
+		if db != nil {
+			introspected, err := loadRowTypes(ctx, db, names)
+			if err != nil {
+				return nil, fmt.Errorf("introspect return types: %w", err)
+			}
+			rowTypes = append(rowTypes, introspected...)
+		}
+		if err := ResolveReturnTypes(procs, rowTypes, enums); err != nil {
 			return nil, err
 		}
 	}
@@ -131,28 +219,44 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 		outputDir = "./generated"
 	}
 
-	defaultPackage := opts.PackageName
-	if defaultPackage == "" {
-		defaultPackage = opts.GeneratorOptions.PackageName
+	pkgName := opts.PackageName
+	if pkgName == "" {
+		pkgName = opts.GeneratorOptions.PackageName
 	}
-	if defaultPackage == "" {
-		defaultPackage = "generated"
+	if pkgName == "" {
+		pkgName = "generated"
+	}
+
+	var schemaOpts SchemaModelOptions
+	var schemaTables []*Table
+	if opts.SchemaModels != nil {
+		if db == nil {
+			return nil, errors.New("sqlproc: schema model generation requires a database connection or DBURL")
+		}
+		schemaOpts = opts.SchemaModels.withDefaults(outputDir, pkgName)
+		schemaTables, err = loadSchemaTables(ctx, db, schemaOpts)
+		if err != nil {
+			return nil, fmt.Errorf("introspect schema: %w", err)
+		}
 	}
 
 	var generatedFiles []string
 	if !opts.SkipGenerate {
-		pkgName := opts.PackageName
-		if pkgName == "" {
-			pkgName = opts.GeneratorOptions.PackageName
+		genOpts := opts.GeneratorOptions
+		genOpts.PackageName = pkgName
+		if len(genOpts.Enums) == 0 {
+			genOpts.Enums = enums
+		}
+		if genOpts.SchemaTables == nil && opts.SchemaModels != nil && sharesSchemaModels(schemaOpts, genOpts, outputDir) {
+			genOpts.SchemaTables = schemaTables
 		}
-		if pkgName == "" {
-			pkgName = "generated"
+		if genOpts.SchemaQueries && genOpts.SchemaTables == nil {
+			return nil, errors.New("sqlproc: schema queries require schema models in the same directory and package, with the same null style and type overrides")
 		}
-		if len(procs) == 0 {
+		tableQueries := genOpts.SchemaQueries && len(genOpts.SchemaTables) > 0
+		if len(procs) == 0 && !tableQueries {
 			logWriter.Printf("no procedures to generate; skipping code emission")
 		} else {
-			genOpts := opts.GeneratorOptions
-			genOpts.PackageName = pkgName
 			gen := NewGenerator(genOpts)
 			if err := gen.Generate(procs, outputDir); err != nil {
 				return nil, fmt.Errorf("generate Go code: %w", err)
@@ -160,25 +264,26 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 			generatedFiles = []string{
 				filepath.Join(outputDir, "db.go"),
 				filepath.Join(outputDir, "models.go"),
-				filepath.Join(outputDir, "queries.go"),
+			}
+			if len(procs) > 0 {
+				generatedFiles = append(generatedFiles, filepath.Join(outputDir, "queries.go"))
+			}
+			if tableQueries {
+				generatedFiles = append(generatedFiles, filepath.Join(outputDir, "schema_queries.go"))
+			}
+			if genOpts.EmitMock {
+				generatedFiles = append(generatedFiles, filepath.Join(outputDir, "mock_querier.go"))
+			}
+			if len(genOpts.Enums) > 0 {
+				generatedFiles = append(generatedFiles, filepath.Join(outputDir, "enums.go"))
 			}
 			logWriter.Printf("generated Go package %q in %s", pkgName, outputDir)
 		}
 	}
 
-	var schemaTables []*Table
 	var schemaFiles []string
 	if opts.SchemaModels != nil {
-		if db == nil {
-			return nil, errors.New("sqlproc: schema model generation requires a database connection or DBURL")
-		}
-		schemaOpts := opts.SchemaModels.withDefaults(outputDir, defaultPackage)
-		var err error
-		schemaTables, err = loadSchemaTables(ctx, db, schemaOpts)
-		if err != nil {
-			return nil, fmt.Errorf("introspect schema: %w", err)
-		}
-		generator := &SchemaModelGenerator{Options: schemaOpts}
+		generator := &SchemaModelGenerator{Options: schemaOpts, Enums: enums}
 		schemaFiles, err = generator.Generate(schemaTables)
 		if err != nil {
 			return nil, fmt.Errorf("generate schema models: %w", err)
@@ -200,6 +305,16 @@ func Run(ctx context.Context, opts PipelineOptions) (*PipelineResult, error) {
 	}, nil
 }
 
+// sharesSchemaModels reports whether schema models are written to the same
+// package as the procedure code with the same type mapping, so procedure
+// row types can reuse the model structs.
+func sharesSchemaModels(schemaOpts SchemaModelOptions, genOpts GeneratorOptions, outputDir string) bool {
+	return filepath.Clean(schemaOpts.OutputDir) == filepath.Clean(outputDir) &&
+		schemaOpts.PackageName == genOpts.PackageName &&
+		schemaOpts.NullStyle == genOpts.NullStyle &&
+		slices.Equal(schemaOpts.TypeOverrides, genOpts.TypeOverrides)
+}
+
 func prepareDB(ctx context.Context, opts PipelineOptions) (*sql.DB, func(), error) {
 	db := opts.DB
 	if db != nil {
@@ -223,14 +338,82 @@ func prepareDB(ctx context.Context, opts PipelineOptions) (*sql.DB, func(), erro
 	return newDB, func() { _ = newDB.Close() }, nil
 }
 
-func runMigrations(ctx context.Context, db *sql.DB, schemaMigrations []*SchemaMigration, procs []*Procedure) error {
+func runMigrations(ctx context.Context, db *sql.DB, opts PipelineOptions, logger Logger, schemaMigrations []*SchemaMigration, procs []*Procedure) (err error) {
+	if err := opts.TransactionScope.validate(); err != nil {
+		return err
+	}
+	var conn sqlConn = db
+	if !opts.SkipMigrationLock {
+		key := opts.MigrationLockKey
+		if key == 0 {
+			key = DefaultMigrationLockKey
+		}
+		locked, release, err := acquireMigrationLock(ctx, db, key, opts.MigrationLockTimeout, logger)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if releaseErr := release(); releaseErr != nil && err == nil {
+				err = releaseErr
+			}
+		}()
+		conn = locked
+	}
+	schemaMigrator := &SchemaMigrator{db: conn}
+	schemaMigrator.DriftPolicy = opts.DriftPolicy
+	schemaMigrator.Logger = logger
+	procMigrator := &Migrator{db: conn}
+	procMigrator.Force = opts.ForceProcedures
+	procMigrator.Prune = opts.PruneProcedures
+	procMigrator.DropCascade = opts.DropCascade
+	procMigrator.DropUntrackedOverloads = opts.DropUntrackedOverloads
+	procMigrator.SingleTransaction = opts.TransactionScope == TransactionProcedures
+	procMigrator.Logger = logger
+
+	if opts.TransactionScope == TransactionAll {
+		return withTx(ctx, conn, nil, func(tx *sql.Tx) error {
+			if len(schemaMigrations) > 0 {
+				if err := schemaMigrator.MigrateTx(ctx, tx, schemaMigrations); err != nil {
+					return fmt.Errorf("schema migrations: %w", err)
+				}
+			}
+			if err := procMigrator.MigrateTx(ctx, tx, procs); err != nil {
+				return fmt.Errorf("procedure migrations: %w", err)
+			}
+			return nil
+		})
+	}
 	if len(schemaMigrations) > 0 {
-		if err := NewSchemaMigrator(db).Migrate(ctx, schemaMigrations); err != nil {
+		if err := schemaMigrator.Migrate(ctx, schemaMigrations); err != nil {
 			return fmt.Errorf("schema migrations: %w", err)
 		}
 	}
-	if err := NewMigrator(db).Migrate(ctx, procs); err != nil {
+	if err := procMigrator.Migrate(ctx, procs); err != nil {
 		return fmt.Errorf("procedure migrations: %w", err)
 	}
 	return nil
 }
+
+// TransactionScope selects which migrations Run applies together in a single
+// transaction.
+type TransactionScope string
+
+const (
+	// TransactionPerMigration applies each schema migration and procedure in
+	// its own transaction. This is the default.
+	TransactionPerMigration TransactionScope = "migration"
+	// TransactionProcedures applies all procedures in one transaction.
+	TransactionProcedures TransactionScope = "procedures"
+	// TransactionAll applies schema migrations and procedures in one
+	// transaction. Migrations marked -- sqlproc:no-transaction are rejected.
+	TransactionAll TransactionScope = "all"
+)
+
+func (s TransactionScope) validate() error {
+	switch s {
+	case "", TransactionPerMigration, TransactionProcedures, TransactionAll:
+		return nil
+	default:
+		return fmt.Errorf("unknown transaction scope %q (expected migration, procedures or all)", s)
+	}
+}
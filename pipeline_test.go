@@ -2,11 +2,17 @@ package sqlproc
 
 import (
 	"context"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 )
 
 func TestRun_GenerateOnly(t *testing.T) {
@@ -59,18 +65,37 @@ func TestRun_WithDBAndMigrations(t *testing.T) {
 		t.Fatalf("sqlmock: %v", err)
 	}
 	defer db.Close()
+	// Migrations run on the connection holding the lock, so a single
+	// connection is enough.
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(DefaultMigrationLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
 	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations`).
 		WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectQuery(`SELECT version FROM sqlproc_schema_migrations`).
-		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectQuery(`SELECT version, COALESCE\(checksum, ''\) FROM sqlproc_schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}))
 	mock.ExpectBegin()
 	mock.ExpectExec(`CREATE TABLE foo`).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(`INSERT INTO sqlproc_schema_migrations`).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sqlproc_procedures`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT name, sql_name, signature, file, definition_hash FROM sqlproc_procedures`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT sqlproc_replace`).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(`CREATE OR REPLACE FUNCTION ping_proc`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`RELEASE SAVEPOINT sqlproc_replace`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`JOIN pg_proc cur`).WithArgs("ping_proc()", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"signature"}))
+	mock.ExpectExec(`INSERT INTO sqlproc_procedures`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(DefaultMigrationLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
-	_, err = Run(context.Background(), PipelineOptions{
+	_, err = Run(ctx, PipelineOptions{
 		SQLInputs:       []string{sqlFile},
 		MigrationInputs: []string{migrationFile},
 		DB:              db,
@@ -84,6 +109,149 @@ func TestRun_WithDBAndMigrations(t *testing.T) {
 	}
 }
 
+func TestRun_FromFS(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"sql/funcs/ping.sql":          {Data: []byte(sampleProcedureSQL())},
+		"sql/funcs/README.md":         {Data: []byte("not SQL")},
+		"sql/migrations/001_init.sql": {Data: []byte("CREATE TABLE foo(id INT PRIMARY KEY);\n\n-- +down\nDROP TABLE foo;")},
+	}
+	outDir := filepath.Join(t.TempDir(), "generated")
+
+	result, err := Run(context.Background(), PipelineOptions{
+		FS:              fsys,
+		SQLInputs:       []string{"sql/funcs"},
+		MigrationInputs: []string{"sql/migrations"},
+		OutputDir:       outDir,
+		SkipMigrate:     true,
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(result.Procedures) != 1 || result.Procedures[0].File != "sql/funcs/ping.sql" {
+		t.Fatalf("unexpected procedures %+v", result.Procedures)
+	}
+	if len(result.SchemaMigrations) != 1 || result.SchemaMigrations[0].DownSQL != "DROP TABLE foo;" {
+		t.Fatalf("unexpected migrations %+v", result.SchemaMigrations)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "queries.go")); err != nil {
+		t.Fatalf("expected generated code on disk: %v", err)
+	}
+}
+
+func TestRun_SingleTransaction(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sqlFile := writeTestFile(t, dir, "ping.sql", sampleProcedureSQL())
+	migrationFile := writeTestFile(t, dir, "001_init.sql", "CREATE TABLE foo(id INT PRIMARY KEY);")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The procedure fails, so the schema migration applied earlier in the
+	// same transaction is rolled back too.
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, COALESCE`).WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}))
+	mock.ExpectExec(`CREATE TABLE foo`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO sqlproc_schema_migrations`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sqlproc_procedures`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM sqlproc_procedures`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}))
+	mock.ExpectExec(`SAVEPOINT sqlproc_replace`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE OR REPLACE FUNCTION ping_proc`).
+		WillReturnError(&pq.Error{Message: `syntax error at or near "PERFORM"`, Position: "66"})
+	mock.ExpectRollback()
+
+	_, err = Run(context.Background(), PipelineOptions{
+		SQLInputs:         []string{sqlFile},
+		MigrationInputs:   []string{migrationFile},
+		DB:                db,
+		SkipGenerate:      true,
+		SkipMigrationLock: true,
+		TransactionScope:  TransactionAll,
+	})
+	if err == nil || !strings.Contains(err.Error(), "ping.sql:5: pq: syntax error") {
+		t.Fatalf("expected error located at ping.sql:5, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sqlFile := writeTestFile(t, dir, "ping.sql", sampleProcedureSQL())
+	migrationFile := writeTestFile(t, dir, "001_init.sql", "CREATE TABLE foo(id INT PRIMARY KEY);")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Only reads are expected: the migration is pending, ping_proc is not
+	// tracked yet and a hand-written overload of it exists.
+	mock.ExpectQuery(`SELECT to_regclass`).WithArgs(schemaMigrationsTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT to_regclass`).WithArgs(proceduresTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`FROM pg_proc p`).WithArgs("public", "ping_proc", arrayArg{"ping_proc()"}).
+		WillReturnRows(sqlmock.NewRows([]string{"signature"}).AddRow("ping_proc(integer)"))
+
+	var logs strings.Builder
+	result, err := Run(context.Background(), PipelineOptions{
+		SQLInputs:       []string{sqlFile},
+		MigrationInputs: []string{migrationFile},
+		OutputDir:       filepath.Join(dir, "generated"),
+		DB:              db,
+		DryRun:          true,
+		Logger:          log.New(&logs, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	plan := result.Plan
+	if plan == nil || len(plan.PendingMigrations) != 1 || len(plan.ChangedProcedures) != 1 || len(plan.DroppedRoutines) != 0 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	for _, want := range []string{
+		"plan: create or replace function ping_proc",
+		"plan: warning: ping_proc(integer) is an overload of ping_proc that sqlproc does not track",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %q in plan output, got %q", want, logs.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "generated")); !os.IsNotExist(err) {
+		t.Fatalf("dry run must not generate code, stat err = %v", err)
+	}
+
+	// A routine whose recorded definition hash matches is left out of the
+	// plan, and a tracked routine that was removed is pruned.
+	proc := result.Procedures[0]
+	mock.ExpectQuery(`SELECT to_regclass`).WithArgs(proceduresTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT name, sql_name, signature, file, definition_hash FROM sqlproc_procedures`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}).
+			AddRow(proc.Name, proc.SQLName, proc.Signature(), proc.File, proc.DefinitionHash()).
+			AddRow("Old", "old_proc", "old_proc(integer)", "old.sql", "abc"))
+	plan = &MigrationPlan{}
+	if err := (&Migrator{db: db, Prune: true}).plan(context.Background(), result.Procedures, plan); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.ChangedProcedures) != 0 || len(plan.DroppedRoutines) != 1 || plan.DroppedRoutines[0] != "old_proc(integer)" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unexpected plan %+v: unmet expectations: %v", plan, err)
+	}
+}
+
 func TestRun_SchemaModelsOnly(t *testing.T) {
 	t.Parallel()
 	dir := t.TempDir()
@@ -93,11 +261,19 @@ func TestRun_SchemaModelsOnly(t *testing.T) {
 	}
 	defer db.Close()
 
-	queryRegex := "SELECT table_schema, table_name, column_name, data_type, udt_name, is_nullable"
-	rows := sqlmock.NewRows([]string{"table_schema", "table_name", "column_name", "data_type", "udt_name", "is_nullable"}).
-		AddRow("public", "users", "id", "integer", "int4", "NO").
-		AddRow("public", "users", "email", "text", "text", "YES")
+	enumRows := sqlmock.NewRows([]string{"nspname", "typname", "enumlabel"}).
+		AddRow("public", "mood", "happy").
+		AddRow("public", "mood", "sad")
+	mock.ExpectQuery("SELECT n.nspname, t.typname, e.enumlabel").WithArgs("public").WillReturnRows(enumRows)
+
+	queryRegex := "FROM pg_attribute a"
+	rows := sqlmock.NewRows(attributeColumns).
+		AddRow("public", "users", "r", "id", "int4", false, "", "", "").
+		AddRow("public", "users", "r", "email", "text", true, "", "", "").
+		AddRow("public", "users", "r", "mood", "mood", false, "", "", "")
 	mock.ExpectQuery(queryRegex).WithArgs("public").WillReturnRows(rows)
+	mock.ExpectQuery("FROM pg_constraint con").WithArgs("public").
+		WillReturnRows(sqlmock.NewRows(constraintColumns))
 
 	result, err := Run(context.Background(), PipelineOptions{
 		SkipMigrate:  true,
@@ -107,12 +283,64 @@ func TestRun_SchemaModelsOnly(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Run returned error: %v", err)
 	}
-	if len(result.SchemaFiles) != 1 {
-		t.Fatalf("expected 1 schema model file, got %d", len(result.SchemaFiles))
+	if len(result.SchemaFiles) != 2 {
+		t.Fatalf("expected schema models and enums files, got %v", result.SchemaFiles)
 	}
-	if _, err := os.Stat(result.SchemaFiles[0]); err != nil {
+	models, err := os.ReadFile(result.SchemaFiles[0])
+	if err != nil {
 		t.Fatalf("expected schema file to exist: %v", err)
 	}
+	if !strings.Contains(strings.Join(strings.Fields(string(models)), " "), "Mood Mood") {
+		t.Fatalf("expected enum-typed field, got:\n%s", models)
+	}
+	if _, err := os.Stat(result.SchemaFiles[1]); err != nil {
+		t.Fatalf("expected enums file to exist: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRun_SchemaQueries(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT n.nspname, t.typname, e.enumlabel").WithArgs("public").
+		WillReturnRows(sqlmock.NewRows([]string{"nspname", "typname", "enumlabel"}))
+	mock.ExpectQuery("FROM pg_attribute a").WithArgs("public").WillReturnRows(
+		sqlmock.NewRows(attributeColumns).
+			AddRow("public", "users", "r", "id", "int4", false, "", "", "nextval('users_id_seq'::regclass)").
+			AddRow("public", "users", "r", "email", "text", false, "", "", ""))
+	mock.ExpectQuery("FROM pg_constraint con").WithArgs("public").WillReturnRows(
+		sqlmock.NewRows(constraintColumns).AddRow("public", "users", "users_pkey", "p", "id", "", "", ""))
+
+	result, err := Run(context.Background(), PipelineOptions{
+		SkipMigrate:      true,
+		DB:               db,
+		OutputDir:        dir,
+		PackageName:      "models",
+		SchemaModels:     &SchemaModelOptions{Schemas: []string{"public"}},
+		GeneratorOptions: GeneratorOptions{SchemaQueries: true},
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	want := []string{filepath.Join(dir, "db.go"), filepath.Join(dir, "models.go"), filepath.Join(dir, "schema_queries.go")}
+	if !reflect.DeepEqual(result.GeneratedFiles, want) {
+		t.Fatalf("GeneratedFiles = %v, want %v", result.GeneratedFiles, want)
+	}
+	queries, err := os.ReadFile(filepath.Join(dir, "schema_queries.go"))
+	if err != nil {
+		t.Fatalf("read schema queries: %v", err)
+	}
+	if !strings.Contains(string(queries), "func (q *Queries) GetUsersByPK(ctx context.Context, id int32) (GetUsersByPKRow, error)") {
+		t.Fatalf("expected primary key lookup, got:\n%s", queries)
+	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
 	}
@@ -0,0 +1,195 @@
+package sqlproc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// MigrationPlan lists what a migration run would change, as computed by a
+// dry run.
+type MigrationPlan struct {
+	// PendingMigrations are schema migrations that have not been applied.
+	PendingMigrations []*SchemaMigration
+	// DriftedMigrations are applied schema migrations whose file changed.
+	DriftedMigrations []MigrationStatus
+	// ChangedProcedures are routines Migrate would apply: untracked ones and
+	// ones whose definition hash differs from the one recorded in
+	// sqlproc_procedures (or every routine with ForceProcedures).
+	ChangedProcedures []*Procedure
+	// DroppedRoutines are the signatures of routines the run would drop:
+	// overloads previously recorded for a changed procedure, untracked
+	// overloads with DropUntrackedOverloads and, with PruneProcedures,
+	// tracked routines that are no longer in the source tree. A changed
+	// routine whose return type or OUT parameters changed is also dropped
+	// and recreated, which can only be told when it is applied.
+	DroppedRoutines []string
+	// Warnings are reported by the run without acting on them, such as
+	// untracked overloads and orphaned routines.
+	Warnings []string
+}
+
+// Empty reports whether the plan has nothing to apply.
+func (p *MigrationPlan) Empty() bool {
+	return len(p.PendingMigrations) == 0 && len(p.ChangedProcedures) == 0 && len(p.DroppedRoutines) == 0
+}
+
+// planMigrations compares migrations and procedures with the database
+// without changing it. procMigrator carries the options of the run.
+func planMigrations(ctx context.Context, db *sql.DB, migrations []*SchemaMigration, procs []*Procedure, procMigrator *Migrator) (*MigrationPlan, error) {
+	plan := &MigrationPlan{}
+	if len(migrations) > 0 {
+		statuses, err := NewSchemaMigrator(db).Status(ctx, migrations)
+		if err != nil {
+			return nil, fmt.Errorf("schema migration status: %w", err)
+		}
+		byVersion := make(map[int64]*SchemaMigration, len(migrations))
+		for _, migration := range migrations {
+			byVersion[migration.Version] = migration
+		}
+		for _, status := range statuses {
+			switch status.State {
+			case MigrationPending:
+				plan.PendingMigrations = append(plan.PendingMigrations, byVersion[status.Version])
+			case MigrationChecksumMismatch:
+				plan.DriftedMigrations = append(plan.DriftedMigrations, status)
+			}
+		}
+	}
+
+	if err := procMigrator.plan(ctx, procs, plan); err != nil {
+		return nil, fmt.Errorf("compare procedures: %w", err)
+	}
+	return plan, nil
+}
+
+// plan fills the procedure part of a migration plan with the decisions
+// Migrate would make, reading sqlproc_procedures and pg_proc only.
+func (m *Migrator) plan(ctx context.Context, procs []*Procedure, plan *MigrationPlan) error {
+	var exists bool
+	if err := m.conn().QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, proceduresTable).Scan(&exists); err != nil {
+		return err
+	}
+	recorded := map[string]procedureRecord{}
+	if exists {
+		var err error
+		if recorded, err = m.recordedProcedures(ctx); err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string]bool, len(procs))
+	signatures := make(map[string]bool, len(procs))
+	var keep []string
+	for _, proc := range procs {
+		current[proc.Name] = true
+		if !signatures[proc.Signature()] {
+			keep = append(keep, proc.Signature())
+		}
+		signatures[proc.Signature()] = true
+	}
+	for _, proc := range procs {
+		record, ok := recorded[proc.Name]
+		if ok && record.Hash == proc.DefinitionHash() && !m.Force {
+			continue
+		}
+		plan.ChangedProcedures = append(plan.ChangedProcedures, proc)
+		known := append([]string(nil), keep...)
+		if ok && record.Signature != proc.Signature() && !signatures[record.Signature] {
+			known = append(known, record.Signature)
+			identity, err := staleRoutineIdentity(ctx, m.conn(), record.Signature, proc.Signature())
+			if err != nil {
+				return err
+			}
+			if identity != "" {
+				plan.DroppedRoutines = append(plan.DroppedRoutines, record.Signature)
+			}
+		}
+		overloads, err := plannedOverloads(ctx, m.conn(), proc, known)
+		if err != nil {
+			return err
+		}
+		for _, signature := range overloads {
+			if m.DropUntrackedOverloads {
+				plan.DroppedRoutines = append(plan.DroppedRoutines, signature)
+			} else {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s is an overload of %s that sqlproc does not track", signature, proc.SQLName))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(recorded))
+	for name := range recorded {
+		if !current[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		record := recorded[name]
+		switch {
+		case !m.Prune:
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s (%s) is tracked but no longer in the source tree", record.Signature, record.File))
+		case !signatures[record.Signature]:
+			plan.DroppedRoutines = append(plan.DroppedRoutines, record.Signature)
+		}
+	}
+	return nil
+}
+
+// plannedOverloads returns the routines in pg_proc named like proc whose
+// signature is not in known. Unlike untrackedOverloads it does not need proc
+// to exist yet, so an unqualified name is looked up in the public schema.
+func plannedOverloads(ctx context.Context, q sqlQueryer, proc *Procedure, known []string) ([]string, error) {
+	schema, name := splitQualifiedName(proc.SQLName)
+	rows, err := q.QueryContext(ctx, `
+SELECT p.oid::regprocedure::text
+FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = $1 AND p.proname = $2
+	AND NOT EXISTS (SELECT 1 FROM unnest($3::text[]) AS k(signature) WHERE to_regprocedure(k.signature) = p.oid)
+ORDER BY 1`, schema, name, pq.Array(known))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overloads []string
+	for rows.Next() {
+		var signature string
+		if err := rows.Scan(&signature); err != nil {
+			return nil, err
+		}
+		overloads = append(overloads, signature)
+	}
+	return overloads, rows.Err()
+}
+
+// logPlan writes a human-readable summary of plan.
+func logPlan(logger Logger, plan *MigrationPlan) {
+	if plan.Empty() {
+		logger.Printf("plan: nothing to apply")
+	}
+	for _, migration := range plan.PendingMigrations {
+		logger.Printf("plan: apply schema migration %d (%s)", migration.Version, migration.File)
+	}
+	for _, status := range plan.DriftedMigrations {
+		logger.Printf("plan: warning: applied schema migration %d (%s) changed since it ran", status.Version, status.File)
+	}
+	for _, proc := range plan.ChangedProcedures {
+		routine := proc.Routine
+		if routine == "" {
+			routine = RoutineFunction
+		}
+		logger.Printf("plan: create or replace %s %s (%s)", routine, proc.SQLName, proc.File)
+	}
+	for _, signature := range plan.DroppedRoutines {
+		logger.Printf("plan: drop routine %s", signature)
+	}
+	for _, warning := range plan.Warnings {
+		logger.Printf("plan: warning: %s", warning)
+	}
+}
@@ -0,0 +1,251 @@
+package sqlproc
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const proceduresTable = "sqlproc_procedures"
+
+// invalidFunctionDefinition is the SQLSTATE PostgreSQL raises when CREATE OR
+// REPLACE cannot change an existing routine's return type, OUT parameters or
+// parameter names, but also for other invalid definitions.
+const invalidFunctionDefinition = "42P13"
+
+// recreateMessages identify the invalidFunctionDefinition errors that
+// dropping and recreating the routine resolves.
+var recreateMessages = []string{
+	"cannot change return type of existing function",
+	"cannot change name of input parameter",
+	"output parameters",
+}
+
+// needsRecreate reports whether err means CREATE OR REPLACE cannot change the
+// existing routine in place because its return type, OUT parameters or
+// parameter names changed.
+func needsRecreate(err error) bool {
+	if !isSQLState(err, invalidFunctionDefinition) {
+		return false
+	}
+	for _, message := range recreateMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+// procedureRecord is a row of the procedure tracking table.
+type procedureRecord struct {
+	Name      string
+	SQLName   string
+	Signature string
+	File      string
+	Hash      string
+}
+
+// DefinitionHash returns the SHA-256 of the procedure's SQL, recorded when
+// it is applied so unchanged definitions can be skipped.
+func (p *Procedure) DefinitionHash() string {
+	sum := sha256.Sum256([]byte(p.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// Signature returns the routine's identity as accepted by DROP ROUTINE:
+// its SQL name followed by the types of its input arguments.
+func (p *Procedure) Signature() string {
+	inputs := p.InputParams()
+	types := make([]string, 0, len(inputs))
+	for _, param := range inputs {
+		types = append(types, param.DBType)
+	}
+	return p.SQLName + "(" + strings.Join(types, ", ") + ")"
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.conn().ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS `+proceduresTable+` (
+	name TEXT PRIMARY KEY,
+	sql_name TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	file TEXT NOT NULL,
+	definition_hash TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`)
+	return err
+}
+
+// recordedProcedures returns the tracked procedures keyed by Go name.
+func (m *Migrator) recordedProcedures(ctx context.Context) (map[string]procedureRecord, error) {
+	rows, err := m.conn().QueryContext(ctx, `SELECT name, sql_name, signature, file, definition_hash FROM `+proceduresTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string]procedureRecord)
+	for rows.Next() {
+		var record procedureRecord
+		if err := rows.Scan(&record.Name, &record.SQLName, &record.Signature, &record.File, &record.Hash); err != nil {
+			return nil, err
+		}
+		records[record.Name] = record
+	}
+	return records, rows.Err()
+}
+
+func (m *Migrator) recordProcedure(ctx context.Context, exec sqlExecer, proc *Procedure) error {
+	_, err := exec.ExecContext(ctx, `INSERT INTO `+proceduresTable+` (name, sql_name, signature, file, definition_hash, applied_at)
+VALUES ($1, $2, $3, $4, $5, NOW())
+ON CONFLICT (name) DO UPDATE SET sql_name = EXCLUDED.sql_name, signature = EXCLUDED.signature,
+	file = EXCLUDED.file, definition_hash = EXCLUDED.definition_hash, applied_at = EXCLUDED.applied_at`,
+		proc.Name, proc.SQLName, proc.Signature(), proc.File, proc.DefinitionHash())
+	return err
+}
+
+// replaceRoutine runs proc.SQL. When PostgreSQL refuses to replace the
+// existing routine in place, the routine is dropped and created again.
+func (m *Migrator) replaceRoutine(ctx context.Context, tx *sql.Tx, proc *Procedure, logger Logger) error {
+	if proc.SQL == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT sqlproc_replace`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, proc.SQL); err != nil {
+		if !needsRecreate(err) {
+			return err
+		}
+		if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT sqlproc_replace`); rbErr != nil {
+			return rbErr
+		}
+		logger.Printf("recreating %s: %v", proc.Signature(), err)
+		if err := m.dropRoutine(ctx, tx, proc.Signature()); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, proc.SQL); err != nil {
+			return err
+		}
+	}
+	// Release the savepoint so a long SingleTransaction run does not keep a
+	// subtransaction open per procedure.
+	_, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT sqlproc_replace`)
+	return err
+}
+
+// untrackedOverloads finds routines in pg_proc with proc's schema and name
+// whose identity arguments match neither proc nor any signature in keep (the
+// other current procedures). sqlproc did not create them, so they are only
+// reported unless DropUntrackedOverloads is set.
+func (m *Migrator) untrackedOverloads(ctx context.Context, tx *sql.Tx, proc *Procedure, keep []string, logger Logger) error {
+	rows, err := tx.QueryContext(ctx, `
+SELECT p.oid::regprocedure::text
+FROM pg_proc p
+JOIN pg_proc cur ON cur.pronamespace = p.pronamespace AND cur.proname = p.proname AND cur.oid <> p.oid
+WHERE cur.oid = to_regprocedure($1)
+	AND NOT EXISTS (SELECT 1 FROM unnest($2::text[]) AS k(signature) WHERE to_regprocedure(k.signature) = p.oid)
+ORDER BY 1`, proc.Signature(), pq.Array(keep))
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var signature string
+		if err := rows.Scan(&signature); err != nil {
+			rows.Close()
+			return err
+		}
+		stale = append(stale, signature)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, signature := range stale {
+		if !m.DropUntrackedOverloads {
+			logger.Printf("warning: %s is an overload of %s that sqlproc does not track; enable DropUntrackedOverloads to drop it", signature, proc.SQLName)
+			continue
+		}
+		logger.Printf("dropping untracked overload %s", signature)
+		if err := m.dropRoutine(ctx, tx, signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropStaleRoutine drops the routine previously recorded for a procedure
+// whose SQL name or input arguments changed. It is skipped when stale
+// resolves to the same routine as current, e.g. when only the spelling of a
+// type changed, or when it no longer exists.
+func (m *Migrator) dropStaleRoutine(ctx context.Context, tx *sql.Tx, stale, current string, logger Logger) error {
+	identity, err := staleRoutineIdentity(ctx, tx, stale, current)
+	if err != nil || identity == "" {
+		return err
+	}
+	logger.Printf("dropping previous overload %s", identity)
+	return m.dropRoutine(ctx, tx, stale)
+}
+
+// staleRoutineIdentity returns the name and arguments of the routine stale
+// refers to, or "" when it does not exist or is the same routine as current.
+func staleRoutineIdentity(ctx context.Context, q sqlQueryer, stale, current string) (string, error) {
+	var identity string
+	err := q.QueryRowContext(ctx, `
+SELECT p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')'
+FROM pg_proc p
+WHERE p.oid = to_regprocedure($1) AND p.oid IS DISTINCT FROM to_regprocedure($2)`, stale, current).Scan(&identity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return identity, err
+}
+
+// dropRoutine drops the routine with the given signature. Unless DropCascade
+// is set, dependent objects make it fail with a list of the dependents.
+func (m *Migrator) dropRoutine(ctx context.Context, tx *sql.Tx, signature string) error {
+	if m.DropCascade {
+		_, err := tx.ExecContext(ctx, `DROP ROUTINE IF EXISTS `+signature+` CASCADE`)
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, `
+SELECT pg_describe_object(d.classid, d.objid, d.objsubid)
+FROM pg_depend d
+WHERE d.refclassid = 'pg_proc'::regclass AND d.refobjid = to_regprocedure($1) AND d.deptype = 'n'
+ORDER BY 1`, signature)
+	if err != nil {
+		return err
+	}
+	var dependents []string
+	for rows.Next() {
+		var dependent string
+		if err := rows.Scan(&dependent); err != nil {
+			rows.Close()
+			return err
+		}
+		dependents = append(dependents, dependent)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("cannot drop %s: %s depend(s) on it; enable DropCascade to drop them too", signature, strings.Join(dependents, ", "))
+	}
+	_, err = tx.ExecContext(ctx, `DROP ROUTINE IF EXISTS `+signature)
+	return err
+}
+
+// isSQLState reports whether err carries the given SQLSTATE code. Both
+// lib/pq and pgx errors expose it through a SQLState method.
+func isSQLState(err error, code string) bool {
+	var stateErr interface{ SQLState() string }
+	return errors.As(err, &stateErr) && stateErr.SQLState() == code
+}
@@ -0,0 +1,311 @@
+package sqlproc
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestMigratorTracksProcedures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	unchanged := &Procedure{Name: "Ping", SQLName: "ping", File: "ping.sql", SQL: "CREATE OR REPLACE FUNCTION ping() RETURNS void AS $$ $$ LANGUAGE sql;"}
+	changed := &Procedure{
+		Name:    "GetUser",
+		SQLName: "get_user",
+		File:    "users.sql",
+		SQL:     "CREATE OR REPLACE FUNCTION get_user(p_id integer) RETURNS text AS $$ SELECT 'x' $$ LANGUAGE sql;",
+		Params:  []Param{{Name: "p_id", DBType: "integer"}},
+	}
+	if got := changed.Signature(); got != "get_user(integer)" {
+		t.Fatalf("Signature = %q", got)
+	}
+
+	expectRecorded := func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_procedures").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT name, sql_name, signature, file, definition_hash FROM sqlproc_procedures").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}).
+				AddRow("Ping", "ping", "ping()", "ping.sql", unchanged.DefinitionHash()).
+				AddRow("GetUser", "get_user", "get_user(integer)", "users.sql", "stale").
+				AddRow("OldReport", "old_report", "old_report(date)", "reports.sql", "abc"))
+	}
+	expectApply := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE OR REPLACE FUNCTION get_user").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("JOIN pg_proc cur").WithArgs("get_user(integer)", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"signature"}))
+		mock.ExpectExec("INSERT INTO sqlproc_procedures").
+			WithArgs("GetUser", "get_user", "get_user(integer)", "users.sql", changed.DefinitionHash()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	// Unchanged procedures are skipped and orphans are only reported.
+	expectRecorded()
+	expectApply()
+	var logs strings.Builder
+	migrator := NewMigrator(db)
+	migrator.Logger = log.New(&logs, "", 0)
+	if err := migrator.Migrate(context.Background(), []*Procedure{unchanged, changed}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "applied get_user (users.sql)") || strings.Contains(logs.String(), "applied ping") {
+		t.Fatalf("unexpected apply log %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "old_report(date) (reports.sql) is tracked but no longer in the source tree") {
+		t.Fatalf("expected orphan warning, got %q", logs.String())
+	}
+
+	// Prune drops the orphan and forgets it.
+	expectRecorded()
+	expectApply()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM pg_depend d").WithArgs("old_report(date)").
+		WillReturnRows(sqlmock.NewRows([]string{"dependent"}))
+	mock.ExpectExec("DROP ROUTINE IF EXISTS old_report\\(date\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sqlproc_procedures WHERE name = \\$1").WithArgs("OldReport").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	migrator.Prune = true
+	if err := migrator.Migrate(context.Background(), []*Procedure{unchanged, changed}); err != nil {
+		t.Fatalf("Migrate with Prune error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestMigratorPrunesWhenNoProceduresRemain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Without a tracking table there is nothing to report.
+	mock.ExpectQuery("SELECT to_regclass").WithArgs(proceduresTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	migrator := NewMigrator(db)
+	migrator.Prune = true
+	if err := migrator.Migrate(context.Background(), nil); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+
+	// The last tracked procedure is pruned after it left the source tree.
+	mock.ExpectQuery("SELECT to_regclass").WithArgs(proceduresTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT name, sql_name, signature, file, definition_hash FROM sqlproc_procedures").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}).
+			AddRow("OldReport", "old_report", "old_report(date)", "reports.sql", "abc"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM pg_depend d").WithArgs("old_report(date)").
+		WillReturnRows(sqlmock.NewRows([]string{"dependent"}))
+	mock.ExpectExec("DROP ROUTINE IF EXISTS old_report\\(date\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sqlproc_procedures WHERE name = \\$1").WithArgs("OldReport").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := migrator.Migrate(context.Background(), nil); err != nil {
+		t.Fatalf("Migrate with Prune error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// sqlStateError mimics driver errors that expose a SQLSTATE code.
+type sqlStateError struct{ code, message string }
+
+func (e sqlStateError) Error() string    { return e.message }
+func (e sqlStateError) SQLState() string { return e.code }
+
+func TestMigratorReplacesChangedSignatures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	proc := &Procedure{
+		Name:    "GetUser",
+		SQLName: "get_user",
+		File:    "users.sql",
+		SQL:     "CREATE OR REPLACE FUNCTION get_user(p_id bigint) RETURNS TABLE(id bigint, name text) AS $$ SELECT 1, 'x' $$ LANGUAGE sql;",
+		Params:  []Param{{Name: "p_id", DBType: "bigint"}},
+	}
+	expectRecorded := func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_procedures").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM sqlproc_procedures").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}).
+				AddRow("GetUser", "get_user", "get_user(integer)", "users.sql", "stale"))
+	}
+	expectRecreate := func(dependents *sqlmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE OR REPLACE FUNCTION get_user").WillReturnError(sqlStateError{"42P13", "cannot change return type of existing function"})
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM pg_depend d").WithArgs("get_user(bigint)").WillReturnRows(dependents)
+	}
+
+	// The return type changed and the recorded integer overload is left
+	// over: both are dropped in the transaction that recreates the function.
+	expectRecorded()
+	expectRecreate(sqlmock.NewRows([]string{"dependent"}))
+	mock.ExpectExec("DROP ROUTINE IF EXISTS get_user\\(bigint\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE FUNCTION get_user").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("WHERE p.oid = to_regprocedure\\(\\$1\\)").WithArgs("get_user(integer)", "get_user(bigint)").
+		WillReturnRows(sqlmock.NewRows([]string{"identity"}).AddRow("get_user(p_id integer)"))
+	mock.ExpectQuery("FROM pg_depend d").WithArgs("get_user(integer)").
+		WillReturnRows(sqlmock.NewRows([]string{"dependent"}))
+	mock.ExpectExec("DROP ROUTINE IF EXISTS get_user\\(integer\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("JOIN pg_proc cur").WithArgs("get_user(bigint)", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"signature"}))
+	mock.ExpectExec("INSERT INTO sqlproc_procedures").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	var logs strings.Builder
+	migrator := NewMigrator(db)
+	migrator.Logger = log.New(&logs, "", 0)
+	if err := migrator.Migrate(context.Background(), []*Procedure{proc}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "dropping previous overload get_user(p_id integer)") {
+		t.Fatalf("expected overload log, got %q", logs.String())
+	}
+
+	// Dependents make the migration fail and roll back unless DropCascade is set.
+	expectRecorded()
+	expectRecreate(sqlmock.NewRows([]string{"dependent"}).AddRow("view active_users"))
+	mock.ExpectRollback()
+	err = migrator.Migrate(context.Background(), []*Procedure{proc})
+	if err == nil || !strings.Contains(err.Error(), "view active_users depend(s) on it") {
+		t.Fatalf("expected dependents error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestMigratorUntrackedOverloads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	byID := &Procedure{Name: "GetUser", SQLName: "get_user", File: "users.sql", Params: []Param{{Name: "p_id", DBType: "bigint"}},
+		SQL: "CREATE OR REPLACE FUNCTION get_user(p_id bigint) RETURNS text AS $$ SELECT 'x' $$ LANGUAGE sql;"}
+	byEmail := &Procedure{Name: "GetUserByEmail", SQLName: "get_user", File: "users.sql", Params: []Param{{Name: "p_email", DBType: "text"}},
+		SQL: "CREATE OR REPLACE FUNCTION get_user(p_email text) RETURNS text AS $$ SELECT p_email $$ LANGUAGE sql;"}
+	keep := arrayArg{"get_user(bigint)", "get_user(text)"}
+
+	// Nothing is tracked yet and get_user(integer) was created by hand. The
+	// text overload is a current procedure and is never reported.
+	expectApply := func(drop bool) {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_procedures").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM sqlproc_procedures").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}))
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE OR REPLACE FUNCTION get_user\\(p_id bigint\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("JOIN pg_proc cur").WithArgs("get_user(bigint)", keep).
+			WillReturnRows(sqlmock.NewRows([]string{"signature"}).AddRow("get_user(integer)"))
+		if drop {
+			mock.ExpectQuery("FROM pg_depend d").WithArgs("get_user(integer)").
+				WillReturnRows(sqlmock.NewRows([]string{"dependent"}))
+			mock.ExpectExec("DROP ROUTINE IF EXISTS get_user\\(integer\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec("INSERT INTO sqlproc_procedures").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE OR REPLACE FUNCTION get_user\\(p_email text\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("JOIN pg_proc cur").WithArgs("get_user(text)", keep).
+			WillReturnRows(sqlmock.NewRows([]string{"signature"}))
+		mock.ExpectExec("INSERT INTO sqlproc_procedures").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	// By default the untracked overload is only reported.
+	expectApply(false)
+	var logs strings.Builder
+	migrator := NewMigrator(db)
+	migrator.Logger = log.New(&logs, "", 0)
+	if err := migrator.Migrate(context.Background(), []*Procedure{byID, byEmail}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "warning: get_user(integer) is an overload of get_user that sqlproc does not track") {
+		t.Fatalf("expected untracked overload warning, got %q", logs.String())
+	}
+
+	// DropUntrackedOverloads drops it.
+	expectApply(true)
+	logs.Reset()
+	migrator.DropUntrackedOverloads = true
+	if err := migrator.Migrate(context.Background(), []*Procedure{byID, byEmail}); err != nil {
+		t.Fatalf("Migrate with DropUntrackedOverloads error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "dropping untracked overload get_user(integer)") {
+		t.Fatalf("expected drop log, got %q", logs.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestMigratorReportsOtherInvalidDefinitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	proc := &Procedure{Name: "Greet", SQLName: "greet", File: "greet.sql", Params: []Param{{Name: "p_name", DBType: "text"}},
+		SQL: "CREATE OR REPLACE FUNCTION greet(p_name text, p_name text) RETURNS text AS $$ SELECT 'hi' $$ LANGUAGE sql;"}
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_procedures").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM sqlproc_procedures").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sql_name", "signature", "file", "definition_hash"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT sqlproc_replace").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE FUNCTION greet").
+		WillReturnError(sqlStateError{"42P13", `parameter name "p_name" used more than once`})
+	mock.ExpectRollback()
+
+	err = NewMigrator(db).Migrate(context.Background(), []*Procedure{proc})
+	if err == nil || !strings.Contains(err.Error(), `parameter name "p_name" used more than once`) {
+		t.Fatalf("expected the definition error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// arrayArg matches a pq.Array([]string) argument.
+type arrayArg []string
+
+func (a arrayArg) Match(v driver.Value) bool {
+	var got pq.StringArray
+	if err := got.Scan(v); err != nil {
+		return false
+	}
+	return slices.Equal([]string(got), a)
+}
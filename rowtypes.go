@@ -0,0 +1,317 @@
+package sqlproc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RowType describes the columns of a table, view or composite type that a
+// routine returns with RETURNS SETOF name or RETURNS name.
+type RowType struct {
+	Schema  string
+	Name    string
+	Columns []Column
+}
+
+const identPattern = `((?:"[^"]+"|[A-Za-z0-9_]+)(?:\.(?:"[^"]+"|[A-Za-z0-9_]+))?)`
+
+var (
+	createTablePattern  = regexp.MustCompile(`(?is)^create\s+(?:(?:global|local)\s+)?(?:(?:temp|temporary|unlogged)\s+)?table\s+(?:if\s+not\s+exists\s+)?` + identPattern + `\s*\(`)
+	createTypePattern   = regexp.MustCompile(`(?is)^create\s+type\s+` + identPattern + `\s+as\s*\(`)
+	alterTablePattern   = regexp.MustCompile(`(?is)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?` + identPattern + `\s+(.+)$`)
+	dropRelationPattern = regexp.MustCompile(`(?is)^drop\s+(?:table|type)\s+(?:if\s+exists\s+)?(.+?)(?:\s+(?:cascade|restrict))?$`)
+
+	alterRenameToPattern     = regexp.MustCompile(`(?is)^rename\s+to\s+` + identPattern + `$`)
+	alterAddColumnPattern    = regexp.MustCompile(`(?is)^add\s+(?:column\s+)?(?:if\s+not\s+exists\s+)?(.+)$`)
+	alterDropColumnPattern   = regexp.MustCompile(`(?is)^drop\s+(?:column\s+)?(?:if\s+exists\s+)?("[^"]+"|[A-Za-z0-9_]+)`)
+	alterRenameColumnPattern = regexp.MustCompile(`(?is)^rename\s+(?:column\s+)?("[^"]+"|[A-Za-z0-9_]+)\s+to\s+("[^"]+"|[A-Za-z0-9_]+)$`)
+	alterColumnTypePattern   = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?("[^"]+"|[A-Za-z0-9_]+)\s+(?:set\s+data\s+)?type\s+(.+?)(?:\s+(?:using|collate)\s.*)?$`)
+	alterColumnNullPattern   = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?("[^"]+"|[A-Za-z0-9_]+)\s+(set|drop)\s+not\s+null$`)
+
+	columnConstraintPattern = regexp.MustCompile(`(?i)\s+(not\s+null|null|default|primary\s+key|references|unique|check|constraint|generated|collate)\b`)
+	notNullPattern          = regexp.MustCompile(`(?i)\bnot\s+null\b|\bprimary\s+key\b`)
+)
+
+var tableConstraintKeywords = map[string]bool{
+	"constraint": true,
+	"primary":    true,
+	"unique":     true,
+	"check":      true,
+	"foreign":    true,
+	"exclude":    true,
+	"like":       true,
+}
+
+// RowTypesFromMigrations derives row types from the CREATE TABLE, CREATE TYPE
+// ... AS (...) and ALTER TABLE statements of schema migrations, applied in
+// order. Statements it does not understand are ignored.
+func RowTypesFromMigrations(migrations []*SchemaMigration) []*RowType {
+	var types []*RowType
+	find := func(schema, name string) int {
+		for i, t := range types {
+			if t.Schema == schema && t.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	define := func(rt *RowType) {
+		if i := find(rt.Schema, rt.Name); i >= 0 {
+			types[i] = rt
+			return
+		}
+		types = append(types, rt)
+	}
+
+	for _, mig := range migrations {
+		for _, stmt := range splitSQLStatements(mig.SQL) {
+			stmt = strings.TrimSpace(stripSQLComments(stmt))
+			switch {
+			case createTablePattern.MatchString(stmt):
+				loc := createTablePattern.FindStringSubmatchIndex(stmt)
+				schema, name := splitQualifiedName(stmt[loc[2]:loc[3]])
+				define(&RowType{Schema: schema, Name: name, Columns: parseColumnDefs(stmt[loc[1]-1:], false)})
+			case createTypePattern.MatchString(stmt):
+				loc := createTypePattern.FindStringSubmatchIndex(stmt)
+				schema, name := splitQualifiedName(stmt[loc[2]:loc[3]])
+				define(&RowType{Schema: schema, Name: name, Columns: parseColumnDefs(stmt[loc[1]-1:], true)})
+			case alterTablePattern.MatchString(stmt):
+				m := alterTablePattern.FindStringSubmatch(stmt)
+				if i := find(splitQualifiedName(m[1])); i >= 0 {
+					applyAlterTable(types[i], m[2])
+				}
+			case dropRelationPattern.MatchString(stmt):
+				m := dropRelationPattern.FindStringSubmatch(stmt)
+				for _, target := range splitTopLevel(m[1]) {
+					if i := find(splitQualifiedName(target)); i >= 0 {
+						types = append(types[:i], types[i+1:]...)
+					}
+				}
+			}
+		}
+	}
+	return types
+}
+
+// parseColumnDefs parses a parenthesised column list, skipping table
+// constraints. Composite type attributes are always nullable.
+func parseColumnDefs(body string, composite bool) []Column {
+	end := matchingParen(body)
+	if end < 0 {
+		return nil
+	}
+	var columns []Column
+	for _, def := range splitTopLevel(body[1:end]) {
+		if col, ok := parseColumnDef(def, composite); ok {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
+
+func parseColumnDef(def string, composite bool) (Column, bool) {
+	def = strings.TrimSpace(def)
+	fields := strings.Fields(def)
+	if len(fields) < 2 || tableConstraintKeywords[strings.ToLower(fields[0])] {
+		return Column{}, false
+	}
+	name := foldIdent(fields[0])
+	rest := strings.TrimSpace(def[len(fields[0]):])
+	dbType, constraints := rest, ""
+	if loc := columnConstraintPattern.FindStringIndex(rest); loc != nil {
+		dbType, constraints = rest[:loc[0]], rest[loc[0]:]
+	}
+	return Column{
+		Name:     name,
+		DBType:   normalizeType(dbType),
+		Nullable: composite || !notNullPattern.MatchString(constraints),
+	}, true
+}
+
+// applyAlterTable applies the column changes of an ALTER TABLE action list.
+func applyAlterTable(rt *RowType, actions string) {
+	if m := alterRenameToPattern.FindStringSubmatch(strings.TrimSpace(actions)); m != nil {
+		_, rt.Name = splitQualifiedName(m[1])
+		return
+	}
+	column := func(name string) int {
+		for i, col := range rt.Columns {
+			if col.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, action := range splitTopLevel(actions) {
+		action = strings.TrimSpace(action)
+		switch {
+		case alterRenameColumnPattern.MatchString(action):
+			m := alterRenameColumnPattern.FindStringSubmatch(action)
+			if i := column(foldIdent(m[1])); i >= 0 {
+				rt.Columns[i].Name = foldIdent(m[2])
+			}
+		case alterColumnNullPattern.MatchString(action):
+			m := alterColumnNullPattern.FindStringSubmatch(action)
+			if i := column(foldIdent(m[1])); i >= 0 {
+				rt.Columns[i].Nullable = strings.EqualFold(m[2], "drop")
+			}
+		case alterColumnTypePattern.MatchString(action):
+			m := alterColumnTypePattern.FindStringSubmatch(action)
+			if i := column(foldIdent(m[1])); i >= 0 {
+				rt.Columns[i].DBType = normalizeType(m[2])
+			}
+		case alterAddColumnPattern.MatchString(action):
+			m := alterAddColumnPattern.FindStringSubmatch(action)
+			if col, ok := parseColumnDef(m[1], false); ok && column(col.Name) < 0 {
+				rt.Columns = append(rt.Columns, col)
+			}
+		case alterDropColumnPattern.MatchString(action):
+			m := alterDropColumnPattern.FindStringSubmatch(action)
+			if i := column(foldIdent(m[1])); i >= 0 {
+				rt.Columns = append(rt.Columns[:i], rt.Columns[i+1:]...)
+			}
+		}
+	}
+}
+
+// splitQualifiedName splits "schema.name" into its parts, defaulting the
+// schema to public.
+func splitQualifiedName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if idx := strings.Index(name, "."); idx >= 0 && !strings.HasPrefix(name, `"`) {
+		return foldIdent(name[:idx]), foldIdent(name[idx+1:])
+	}
+	if strings.HasPrefix(name, `"`) {
+		if end := strings.Index(name[1:], `"`) + 1; end > 0 && end+1 < len(name) && name[end+1] == '.' {
+			return unquoteIdent(name[:end+1]), foldIdent(name[end+2:])
+		}
+	}
+	return "public", foldIdent(name)
+}
+
+// foldIdent applies PostgreSQL identifier folding: quoted names keep their
+// case, unquoted names are lowercased.
+func foldIdent(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, `"`) {
+		return unquoteIdent(name)
+	}
+	return strings.ToLower(name)
+}
+
+// loadRowTypes introspects the columns of the named tables, views and
+// composite types through pg_attribute.
+func loadRowTypes(ctx context.Context, db *sql.DB, names []string) ([]*RowType, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	queryBuilder := strings.Builder{}
+	queryBuilder.WriteString(`
+SELECT n.nspname, c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_attribute a ON a.attrelid = c.oid
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f', 'c')
+  AND a.attnum > 0 AND NOT a.attisdropped
+  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+  AND c.relname IN (`)
+	args := make([]any, 0, len(names))
+	for i, name := range names {
+		if i > 0 {
+			queryBuilder.WriteString(", ")
+		}
+		queryBuilder.WriteString(fmt.Sprintf("$%d", i+1))
+		args = append(args, name)
+	}
+	queryBuilder.WriteString(") ORDER BY n.nspname, c.relname, a.attnum")
+
+	rows, err := db.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []*RowType
+	for rows.Next() {
+		var schema, name, column, dbType string
+		var notNull bool
+		if err := rows.Scan(&schema, &name, &column, &dbType, &notNull); err != nil {
+			return nil, err
+		}
+		if n := len(types); n == 0 || types[n-1].Schema != schema || types[n-1].Name != name {
+			types = append(types, &RowType{Schema: schema, Name: name})
+		}
+		last := types[len(types)-1]
+		last.Columns = append(last.Columns, Column{Name: column, DBType: normalizeType(dbType), Nullable: !notNull})
+	}
+	return types, rows.Err()
+}
+
+// ResolveReturnTypes fills the result columns of procedures whose RETURNS
+// clause names a table, view or composite type. Later row types take
+// precedence over earlier ones with the same name. Enum return types yield a
+// single column named after the routine.
+func ResolveReturnTypes(procs []*Procedure, rowTypes []*RowType, enums []*Enum) error {
+	index := make(map[string]*RowType, len(rowTypes)*2)
+	for _, rt := range rowTypes {
+		index[rt.Schema+"."+rt.Name] = rt
+		if _, taken := index[rt.Name]; !taken || rt.Schema == "public" {
+			index[rt.Name] = rt
+		}
+	}
+	enumNames := enumTypeNames(enums)
+	for _, proc := range procs {
+		if !needsReturnType(proc) {
+			continue
+		}
+		key := rowTypeKey(proc.ReturnType)
+		if rt, ok := index[key]; ok {
+			proc.Returns = append([]Column(nil), rt.Columns...)
+			continue
+		}
+		if _, ok := enumNames[key]; ok {
+			name := proc.SQLName
+			if idx := strings.LastIndex(name, "."); idx >= 0 {
+				name = name[idx+1:]
+			}
+			proc.Returns = []Column{{Name: name, DBType: proc.ReturnType}}
+			continue
+		}
+		return fmt.Errorf("%s:%d: cannot resolve return type %s of %s; add it to the migrations or declare -- returns columns", proc.File, proc.StartLine, proc.ReturnType, proc.Name)
+	}
+	return nil
+}
+
+// needsReturnType reports whether proc returns rows whose columns must be
+// looked up by the name of its return type.
+func needsReturnType(proc *Procedure) bool {
+	return proc.ReturnType != "" && len(proc.Returns) == 0 && proc.Kind != ReturnExec && !isPseudoType(proc.ReturnType)
+}
+
+// returnTypeNames lists the unqualified names of unresolved return types.
+func returnTypeNames(procs []*Procedure) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, proc := range procs {
+		if !needsReturnType(proc) {
+			continue
+		}
+		_, name := splitQualifiedName(proc.ReturnType)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func rowTypeKey(dbType string) string {
+	schema, name := splitQualifiedName(dbType)
+	if strings.Contains(strings.TrimSpace(dbType), ".") {
+		return schema + "." + name
+	}
+	return name
+}
@@ -0,0 +1,223 @@
+package sqlproc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRowTypesFromMigrations(t *testing.T) {
+	migrations := []*SchemaMigration{
+		{Version: 1, SQL: `
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    nickname text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT users_email_check CHECK (email <> '')
+);
+CREATE TYPE mood AS ENUM ('happy', 'sad');
+CREATE TYPE billing.address AS (street text, city text, zip varchar(10));
+`},
+		{Version: 2, SQL: `
+ALTER TABLE users ADD COLUMN age integer, DROP COLUMN nickname;
+ALTER TABLE public.users RENAME COLUMN created_at TO joined_at;
+ALTER TABLE users ALTER COLUMN age SET NOT NULL;
+`},
+	}
+
+	types := RowTypesFromMigrations(migrations)
+	if len(types) != 2 {
+		t.Fatalf("expected users and billing.address, got %d row types", len(types))
+	}
+	users := types[0]
+	if users.Schema != "public" || users.Name != "users" {
+		t.Fatalf("unexpected first row type %s.%s", users.Schema, users.Name)
+	}
+	wantUsers := []Column{
+		{Name: "id", DBType: "serial"},
+		{Name: "email", DBType: "varchar(255)"},
+		{Name: "joined_at", DBType: "timestamptz"},
+		{Name: "age", DBType: "integer"},
+	}
+	if !reflect.DeepEqual(users.Columns, wantUsers) {
+		t.Fatalf("users columns = %+v; want %+v", users.Columns, wantUsers)
+	}
+	address := types[1]
+	if address.Schema != "billing" || address.Name != "address" || len(address.Columns) != 3 {
+		t.Fatalf("unexpected composite type %+v", address)
+	}
+	if !address.Columns[2].Nullable || address.Columns[2].DBType != "varchar(10)" {
+		t.Fatalf("unexpected composite attribute %+v", address.Columns[2])
+	}
+}
+
+func TestResolveReturnTypes(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "users.sql", `-- name: ListUsers :many
+CREATE OR REPLACE FUNCTION list_users()
+RETURNS SETOF users AS $$
+    SELECT * FROM users;
+$$ LANGUAGE sql;
+
+-- name: GetAddress :one
+CREATE OR REPLACE FUNCTION get_address(p_id integer)
+RETURNS billing.address AS $$
+    SELECT street, city, zip FROM addresses WHERE id = p_id;
+$$ LANGUAGE sql;
+`)
+	procs, err := NewParser().ParseFileAll(path)
+	if err != nil {
+		t.Fatalf("ParseFileAll error: %v", err)
+	}
+	if procs[0].ReturnType != "users" || procs[1].ReturnType != "billing.address" {
+		t.Fatalf("unexpected return types %q, %q", procs[0].ReturnType, procs[1].ReturnType)
+	}
+
+	if err := ResolveReturnTypes(procs, nil, nil); err == nil || !strings.Contains(err.Error(), "cannot resolve return type users") {
+		t.Fatalf("expected unresolved return type error, got %v", err)
+	}
+
+	rowTypes := []*RowType{
+		{Schema: "public", Name: "users", Columns: []Column{{Name: "id", DBType: "integer"}, {Name: "email", DBType: "text"}}},
+		{Schema: "billing", Name: "address", Columns: []Column{{Name: "street", DBType: "text", Nullable: true}}},
+	}
+	if err := ResolveReturnTypes(procs, rowTypes, nil); err != nil {
+		t.Fatalf("ResolveReturnTypes error: %v", err)
+	}
+	if len(procs[0].Returns) != 2 || procs[0].Returns[1].Name != "email" {
+		t.Fatalf("unexpected users returns %+v", procs[0].Returns)
+	}
+	if len(procs[1].Returns) != 1 || !procs[1].Returns[0].Nullable {
+		t.Fatalf("unexpected address returns %+v", procs[1].Returns)
+	}
+}
+
+func TestGeneratorReusesSchemaStructs(t *testing.T) {
+	dir := t.TempDir()
+	users := &Table{Schema: "public", Name: "users", Columns: []TableColumn{
+		{Name: "email", DBType: "text", Nullable: true},
+		{Name: "id", DBType: "int4"},
+	}}
+	procs := []*Procedure{
+		{
+			Name:       "ListUsers",
+			SQLName:    "list_users",
+			Kind:       ReturnMany,
+			ReturnType: "users",
+			Returns:    []Column{{Name: "id", DBType: "integer"}, {Name: "email", DBType: "text", Nullable: true}},
+		},
+		{
+			Name:       "ListUserIDs",
+			SQLName:    "list_user_ids",
+			Kind:       ReturnMany,
+			ReturnType: "users",
+			Returns:    []Column{{Name: "id", DBType: "integer"}},
+		},
+	}
+	cg := &CodeGenerator{OutputDir: dir, PackageName: "gen", SchemaTables: []*Table{users}}
+	if err := cg.Generate(procs); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	models, err := os.ReadFile(filepath.Join(dir, "models.go"))
+	if err != nil {
+		t.Fatalf("read models: %v", err)
+	}
+	if !strings.Contains(string(models), "type ListUsersRow = Users") {
+		t.Fatalf("expected ListUsersRow to alias Users:\n%s", models)
+	}
+	if !strings.Contains(string(models), "type ListUserIDsRow struct") {
+		t.Fatalf("expected ListUserIDsRow to keep its own struct:\n%s", models)
+	}
+}
+
+func TestGeneratorRejectsUnresolvedReturnType(t *testing.T) {
+	cg := &CodeGenerator{OutputDir: t.TempDir()}
+	err := cg.Generate([]*Procedure{{Name: "ListUsers", SQLName: "list_users", Kind: ReturnMany, ReturnType: "users"}})
+	if err == nil || !strings.Contains(err.Error(), "has not been resolved") {
+		t.Fatalf("expected unresolved return type error, got %v", err)
+	}
+}
+
+func TestRun_ResolvesReturnTypesFromDatabase(t *testing.T) {
+	dir := t.TempDir()
+	sqlFile := writeTestFile(t, dir, "users.sql", `-- name: ListUsers :many
+CREATE OR REPLACE FUNCTION list_users()
+RETURNS SETOF users AS $$
+    SELECT * FROM users;
+$$ LANGUAGE sql;
+`)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT n.nspname, t.typname, e.enumlabel").
+		WillReturnRows(sqlmock.NewRows([]string{"nspname", "typname", "enumlabel"}))
+	mock.ExpectQuery("FROM pg_class c").WithArgs("users").
+		WillReturnRows(sqlmock.NewRows([]string{"nspname", "relname", "attname", "format_type", "attnotnull"}).
+			AddRow("public", "users", "id", "integer", true).
+			AddRow("public", "users", "email", "text", false))
+
+	result, err := Run(context.Background(), PipelineOptions{
+		SQLInputs:   []string{sqlFile},
+		OutputDir:   filepath.Join(dir, "generated"),
+		DB:          db,
+		SkipMigrate: true,
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	returns := result.Procedures[0].Returns
+	if len(returns) != 2 || returns[0].Name != "id" || !returns[1].Nullable {
+		t.Fatalf("unexpected resolved columns %+v", returns)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestResolveReturnTypesSkipsExecAndPseudoTypes(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "triggers.sql", `-- name: TouchTrigger :exec
+CREATE OR REPLACE FUNCTION touch_updated_at()
+RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- name: Audit :exec
+CREATE OR REPLACE FUNCTION audit_user(p_id integer)
+RETURNS users AS $$
+    SELECT * FROM users WHERE id = p_id;
+$$ LANGUAGE sql;
+`)
+	procs, err := NewParser().ParseFileAll(path)
+	if err != nil {
+		t.Fatalf("ParseFileAll error: %v", err)
+	}
+	for _, proc := range procs {
+		if proc.ReturnType != "" {
+			t.Fatalf("%s: unexpected return type %q", proc.Name, proc.ReturnType)
+		}
+	}
+	if names := returnTypeNames(procs); len(names) != 0 {
+		t.Fatalf("expected nothing to introspect, got %v", names)
+	}
+
+	procs = []*Procedure{{Name: "Touch", SQLName: "touch", Kind: ReturnExec, ReturnType: "users"}}
+	if err := ResolveReturnTypes(procs, nil, nil); err != nil {
+		t.Fatalf("ResolveReturnTypes error: %v", err)
+	}
+	if err := (&CodeGenerator{OutputDir: dir}).Generate(procs); err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+}
@@ -2,19 +2,29 @@ package sqlproc
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"log"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const schemaMigrationsTable = "sqlproc_schema_migrations"
 
-var migrationFilenamePattern = regexp.MustCompile(`^(\d+)[-_]?([A-Za-z0-9_-]*)\.sql$`)
+var (
+	migrationFilenamePattern = regexp.MustCompile(`^(\d+)[-_]?([A-Za-z0-9_-]*?)(?:\.(up|down))?\.sql$`)
+	migrationUpMarker        = regexp.MustCompile(`(?i)^\s*--\s*\+up\s*$`)
+	migrationDownMarker      = regexp.MustCompile(`(?i)^\s*--\s*\+down\s*$`)
+	noTransactionDirective   = regexp.MustCompile(`(?i)^\s*--\s*sqlproc:no-transaction\s*$`)
+)
 
 // SchemaMigration represents a discrete schema change.
 type SchemaMigration struct {
@@ -22,26 +32,67 @@ type SchemaMigration struct {
 	Name    string
 	File    string
 	SQL     string
+	// DownSQL reverts the migration. It comes from a paired NNN_name.down.sql
+	// file or from the "-- +down" section of the migration file.
+	DownSQL string
+	// NoTransaction is set by a top-level "-- sqlproc:no-transaction" line in
+	// the up SQL. Such migrations run statement by statement outside a
+	// transaction, for CREATE INDEX CONCURRENTLY and similar commands. Down
+	// SQL follows the same rule when it carries the directive itself.
+	NoTransaction bool
+	// StartLine is the 1-based line of File where SQL begins.
+	StartLine int
 }
 
 // LoadSchemaMigrations reads raw SQL migration files and returns structured migrations.
+// NNN_name.up.sql and NNN_name.down.sql files are combined into one migration.
 func LoadSchemaMigrations(files []string) ([]*SchemaMigration, error) {
+	return loadSchemaMigrations(nil, files)
+}
+
+// LoadSchemaMigrationsFS is like LoadSchemaMigrations but reads the files
+// from fsys, such as an embed.FS.
+func LoadSchemaMigrationsFS(fsys fs.FS, files []string) ([]*SchemaMigration, error) {
+	return loadSchemaMigrations(fsys, files)
+}
+
+func loadSchemaMigrations(fsys fs.FS, files []string) ([]*SchemaMigration, error) {
 	if len(files) == 0 {
 		return nil, fmt.Errorf("no schema migration files provided")
 	}
 	migrations := make([]*SchemaMigration, 0, len(files))
-	seen := make(map[int64]string)
+	byVersion := make(map[int64]*SchemaMigration)
+	downFiles := make(map[int64]string)
+	var downs []*SchemaMigration
 	for _, file := range files {
-		mig, err := parseSchemaMigration(file)
+		mig, down, err := parseSchemaMigration(fsys, file)
 		if err != nil {
 			return nil, err
 		}
-		if existing, ok := seen[mig.Version]; ok {
-			return nil, fmt.Errorf("duplicate schema migration version %d (%s and %s)", mig.Version, existing, file)
+		if down {
+			if existing, ok := downFiles[mig.Version]; ok {
+				return nil, fmt.Errorf("duplicate down migration version %d (%s and %s)", mig.Version, existing, file)
+			}
+			downFiles[mig.Version] = file
+			downs = append(downs, mig)
+			continue
+		}
+		if existing, ok := byVersion[mig.Version]; ok {
+			return nil, fmt.Errorf("duplicate schema migration version %d (%s and %s)", mig.Version, existing.File, file)
 		}
-		seen[mig.Version] = file
+		byVersion[mig.Version] = mig
 		migrations = append(migrations, mig)
 	}
+	for _, down := range downs {
+		mig, ok := byVersion[down.Version]
+		if !ok {
+			return nil, fmt.Errorf("down migration %s has no matching up migration", down.File)
+		}
+		if mig.DownSQL != "" {
+			return nil, fmt.Errorf("migration %s has a -- +down section and a separate down file %s", mig.File, down.File)
+		}
+		mig.DownSQL = down.DownSQL
+	}
 	sort.Slice(migrations, func(i, j int) bool {
 		if migrations[i].Version == migrations[j].Version {
 			return migrations[i].Name < migrations[j].Name
@@ -51,42 +102,154 @@ func LoadSchemaMigrations(files []string) ([]*SchemaMigration, error) {
 	return migrations, nil
 }
 
-func parseSchemaMigration(path string) (*SchemaMigration, error) {
+// parseSchemaMigration reads one migration file. The second result reports
+// whether it is a .down.sql file, whose SQL is returned in DownSQL.
+func parseSchemaMigration(fsys fs.FS, path string) (*SchemaMigration, bool, error) {
 	base := filepath.Base(path)
 	matches := migrationFilenamePattern.FindStringSubmatch(base)
 	if matches == nil {
-		return nil, fmt.Errorf("invalid migration filename %q (expected NN_description.sql)", base)
+		return nil, false, fmt.Errorf("invalid migration filename %q (expected NN_description.sql)", base)
 	}
 	version, err := strconv.ParseInt(matches[1], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("parse migration version from %q: %w", base, err)
+		return nil, false, fmt.Errorf("parse migration version from %q: %w", base, err)
 	}
 	if version <= 0 {
-		return nil, fmt.Errorf("migration version must be positive in %q", base)
+		return nil, false, fmt.Errorf("migration version must be positive in %q", base)
 	}
 	name := strings.Trim(matches[2], "-_ ")
 	if name == "" {
 		name = fmt.Sprintf("migration_%d", version)
 	}
-	sqlBytes, err := os.ReadFile(path)
+	sqlBytes, err := readFile(fsys, path)
 	if err != nil {
-		return nil, fmt.Errorf("read migration %s: %w", path, err)
+		return nil, false, fmt.Errorf("read migration %s: %w", path, err)
 	}
-	sqlText := strings.TrimSpace(string(sqlBytes))
-	if sqlText == "" {
-		return nil, fmt.Errorf("migration %s is empty", path)
+	sqlText := string(sqlBytes)
+	if strings.TrimSpace(sqlText) == "" {
+		return nil, false, fmt.Errorf("migration %s is empty", path)
 	}
-	return &SchemaMigration{
+	mig := &SchemaMigration{
 		Version: version,
 		Name:    name,
 		File:    path,
-		SQL:     sqlText,
-	}, nil
+	}
+	if strings.EqualFold(matches[3], "down") {
+		mig.DownSQL = strings.TrimSpace(sqlText)
+		return mig, true, nil
+	}
+	mig.SQL, mig.DownSQL, mig.StartLine = splitDownSection(sqlText)
+	if mig.SQL == "" {
+		return nil, false, fmt.Errorf("migration %s has no up SQL", path)
+	}
+	mig.NoTransaction = hasNoTransactionDirective(mig.SQL)
+	return mig, false, nil
+}
+
+// hasNoTransactionDirective reports whether sqlText contains a top-level
+// "-- sqlproc:no-transaction" line.
+func hasNoTransactionDirective(sqlText string) bool {
+	for _, line := range scanSQLLines(sqlText) {
+		if line.TopLevel && noTransactionDirective.MatchString(line.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitDownSection splits migration text at a top-level "-- +down" line.
+// An optional leading "-- +up" line is dropped. upLine is the file line on
+// which the up SQL starts.
+func splitDownSection(sqlText string) (up, down string, upLine int) {
+	var upLines, downLines []string
+	inDown := false
+	for _, line := range scanSQLLines(sqlText) {
+		switch {
+		case line.TopLevel && !inDown && migrationDownMarker.MatchString(line.Text):
+			inDown = true
+		case line.TopLevel && !inDown && migrationUpMarker.MatchString(line.Text):
+		case inDown:
+			downLines = append(downLines, line.Text)
+		default:
+			if upLine == 0 && strings.TrimSpace(line.Text) != "" {
+				upLine = line.Number
+			}
+			if upLine != 0 {
+				upLines = append(upLines, line.Text)
+			}
+		}
+	}
+	return strings.TrimSpace(strings.Join(upLines, "\n")), strings.TrimSpace(strings.Join(downLines, "\n")), upLine
+}
+
+// Checksum returns the SHA-256 of the migration's up SQL, recorded when the
+// migration is applied and compared on later runs to detect edits.
+func (m *SchemaMigration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// DriftPolicy controls how Migrate reacts when an applied migration file no
+// longer matches the checksum recorded for it.
+type DriftPolicy string
+
+const (
+	// DriftFail aborts Migrate before applying anything. This is the default.
+	DriftFail DriftPolicy = "fail"
+	// DriftWarn logs the mismatch and continues.
+	DriftWarn DriftPolicy = "warn"
+	// DriftIgnore skips checksum verification.
+	DriftIgnore DriftPolicy = "ignore"
+)
+
+func (p DriftPolicy) validate() error {
+	switch p {
+	case "", DriftFail, DriftWarn, DriftIgnore:
+		return nil
+	default:
+		return fmt.Errorf("unknown drift policy %q (expected fail, warn or ignore)", p)
+	}
+}
+
+// MigrationState describes a migration in a Status report.
+type MigrationState string
+
+const (
+	// MigrationApplied migrations ran and still match their file.
+	MigrationApplied MigrationState = "applied"
+	// MigrationPending migrations have a file but have not run.
+	MigrationPending MigrationState = "pending"
+	// MigrationMissing migrations ran but no longer have a file.
+	MigrationMissing MigrationState = "missing"
+	// MigrationChecksumMismatch migrations ran but their file has changed.
+	MigrationChecksumMismatch MigrationState = "checksum-mismatch"
+)
+
+// MigrationStatus is one row of a SchemaMigrator.Status report.
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	// File is empty for MigrationMissing entries.
+	File  string
+	State MigrationState
+	// AppliedAt is zero for MigrationPending entries.
+	AppliedAt time.Time
 }
 
 // SchemaMigrator applies schema migrations with version tracking.
 type SchemaMigrator struct {
-	db *sql.DB
+	db sqlConn
+	// tx is set by MigrateTx; all statements then run inside it.
+	tx *sql.Tx
+
+	// DriftPolicy selects how edited, already-applied migrations are handled.
+	// Defaults to DriftFail.
+	DriftPolicy DriftPolicy
+	// AppliedBy is recorded with each migration. Defaults to the database's
+	// current_user.
+	AppliedBy string
+	// Logger receives drift warnings. Defaults to the standard logger.
+	Logger Logger
 }
 
 // NewSchemaMigrator creates a schema migrator.
@@ -94,20 +257,43 @@ func NewSchemaMigrator(db *sql.DB) *SchemaMigrator {
 	return &SchemaMigrator{db: db}
 }
 
+// MigrateTx is like Migrate but runs everything inside tx, so the caller
+// decides whether the migrations commit together with other work. Migrations
+// marked -- sqlproc:no-transaction are rejected.
+func (m *SchemaMigrator) MigrateTx(ctx context.Context, tx *sql.Tx, migrations []*SchemaMigration) error {
+	scoped := *m
+	scoped.tx = tx
+	return scoped.Migrate(ctx, migrations)
+}
+
+// conn returns the transaction set by MigrateTx or the database handle.
+func (m *SchemaMigrator) conn() sqlQueryer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
 // Migrate applies all pending schema migrations in order.
 func (m *SchemaMigrator) Migrate(ctx context.Context, migrations []*SchemaMigration) error {
 	if len(migrations) == 0 {
 		return nil
 	}
+	if err := m.DriftPolicy.validate(); err != nil {
+		return err
+	}
 	if err := m.ensureTable(ctx); err != nil {
 		return err
 	}
-	applied, err := m.appliedVersions(ctx)
+	applied, err := m.appliedChecksums(ctx)
 	if err != nil {
 		return err
 	}
+	if err := m.checkDrift(migrations, applied); err != nil {
+		return err
+	}
 	for _, migration := range migrations {
-		if applied[migration.Version] {
+		if _, ok := applied[migration.Version]; ok {
 			continue
 		}
 		if err := m.applyMigration(ctx, migration); err != nil {
@@ -122,42 +308,350 @@ func (m *SchemaMigrator) ensureTable(ctx context.Context) error {
 CREATE TABLE IF NOT EXISTS ` + schemaMigrationsTable + ` (
 	version BIGINT PRIMARY KEY,
 	name TEXT NOT NULL,
-	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`
-	_, err := m.db.ExecContext(ctx, createTable)
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	down_sql TEXT,
+	checksum TEXT,
+	applied_by TEXT,
+	execution_ms BIGINT
+);
+ALTER TABLE ` + schemaMigrationsTable + ` ADD COLUMN IF NOT EXISTS down_sql TEXT;
+ALTER TABLE ` + schemaMigrationsTable + ` ADD COLUMN IF NOT EXISTS checksum TEXT;
+ALTER TABLE ` + schemaMigrationsTable + ` ADD COLUMN IF NOT EXISTS applied_by TEXT;
+ALTER TABLE ` + schemaMigrationsTable + ` ADD COLUMN IF NOT EXISTS execution_ms BIGINT;`
+	_, err := m.conn().ExecContext(ctx, createTable)
 	return err
 }
 
-func (m *SchemaMigrator) appliedVersions(ctx context.Context) (map[int64]bool, error) {
-	rows, err := m.db.QueryContext(ctx, `SELECT version FROM `+schemaMigrationsTable)
+// appliedChecksums maps applied versions to their recorded checksum, which
+// is empty for versions applied before checksums were tracked.
+func (m *SchemaMigrator) appliedChecksums(ctx context.Context) (map[int64]string, error) {
+	rows, err := m.conn().QueryContext(ctx, `SELECT version, COALESCE(checksum, '') FROM `+schemaMigrationsTable)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	applied := make(map[int64]bool)
+	applied := make(map[int64]string)
 	for rows.Next() {
 		var version int64
-		if err := rows.Scan(&version); err != nil {
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
 			return nil, err
 		}
-		applied[version] = true
+		applied[version] = checksum
 	}
 	return applied, rows.Err()
 }
 
+// checkDrift compares applied migrations with their recorded checksums.
+func (m *SchemaMigrator) checkDrift(migrations []*SchemaMigration, applied map[int64]string) error {
+	if m.DriftPolicy == DriftIgnore {
+		return nil
+	}
+	var drifted []string
+	for _, migration := range migrations {
+		recorded, ok := applied[migration.Version]
+		if !ok || recorded == "" || recorded == migration.Checksum() {
+			continue
+		}
+		drifted = append(drifted, fmt.Sprintf("%d (%s)", migration.Version, migration.File))
+	}
+	if len(drifted) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("applied schema migration(s) changed since they ran: %s; run repair to accept the edits", strings.Join(drifted, ", "))
+	if m.DriftPolicy == DriftWarn {
+		logger := m.Logger
+		if logger == nil {
+			logger = log.Default()
+		}
+		logger.Printf("warning: %s", msg)
+		return nil
+	}
+	return errors.New(msg)
+}
+
 func (m *SchemaMigrator) applyMigration(ctx context.Context, migration *SchemaMigration) error {
-	tx, err := m.db.BeginTx(ctx, nil)
+	if migration.NoTransaction {
+		if m.tx != nil {
+			return fmt.Errorf("migration %s is marked -- sqlproc:no-transaction and cannot run inside a single transaction", migration.File)
+		}
+		return m.applyMigrationWithoutTransaction(ctx, migration)
+	}
+	return withTx(ctx, m.db, m.tx, func(tx *sql.Tx) error {
+		start := time.Now()
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			return fmt.Errorf("apply migration %w", locateError(err, migration.File, migration.SQL, migration.StartLine))
+		}
+		return m.recordMigration(ctx, tx, migration, time.Since(start))
+	})
+}
+
+// applyMigrationWithoutTransaction runs each statement on its own and
+// records the version only once all of them succeed. A failure leaves the
+// earlier statements applied and the version unrecorded, so the migration
+// runs again from the first statement on the next Migrate.
+func (m *SchemaMigrator) applyMigrationWithoutTransaction(ctx context.Context, migration *SchemaMigration) error {
+	start := time.Now()
+	if err := execStatements(ctx, m.db, migration.SQL); err != nil {
+		return fmt.Errorf("apply non-transactional migration %s: %w; the version was not recorded, so make the applied statements safe to re-run (e.g. IF NOT EXISTS) or undo them before retrying", migration.File, err)
+	}
+	if err := m.recordMigration(ctx, m.db, migration, time.Since(start)); err != nil {
+		return fmt.Errorf("record non-transactional migration %s after it was applied: %w", migration.File, err)
+	}
+	return nil
+}
+
+// sqlExecer is satisfied by *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqlQueryer is satisfied by *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	sqlExecer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlConn is satisfied by *sql.DB and *sql.Conn.
+type sqlConn interface {
+	sqlQueryer
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// withTx runs fn in outer when it is set. Otherwise fn runs in a new
+// transaction on db that is committed if fn succeeds and rolled back if not.
+func withTx(ctx context.Context, db sqlConn, outer *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if outer != nil {
+		return fn(outer)
+	}
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
-		_ = tx.Rollback()
-		return fmt.Errorf("apply migration %s: %w", migration.File, err)
-	}
-	if _, err := tx.ExecContext(ctx, `INSERT INTO `+schemaMigrationsTable+` (version, name) VALUES ($1, $2)`, migration.Version, migration.Name); err != nil {
+	if err := fn(tx); err != nil {
 		_ = tx.Rollback()
 		return err
 	}
 	return tx.Commit()
 }
+
+func (m *SchemaMigrator) recordMigration(ctx context.Context, exec sqlExecer, migration *SchemaMigration, elapsed time.Duration) error {
+	_, err := exec.ExecContext(ctx, `INSERT INTO `+schemaMigrationsTable+` (version, name, down_sql, checksum, applied_by, execution_ms)
+VALUES ($1, $2, NULLIF($3, ''), $4, COALESCE(NULLIF($5, ''), current_user), $6)`,
+		migration.Version, migration.Name, migration.DownSQL, migration.Checksum(), m.AppliedBy, elapsed.Milliseconds())
+	return err
+}
+
+// execStatements executes each top-level statement of sqlText separately,
+// reporting which statement failed.
+func execStatements(ctx context.Context, exec sqlExecer, sqlText string) error {
+	statements := splitSQLStatements(sqlText)
+	for i, statement := range statements {
+		if _, err := exec.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("statement %d of %d failed after %d succeeded: %w", i+1, len(statements), i, err)
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the most recently applied steps migrations, newest first,
+// using the down SQL recorded when each migration was applied.
+func (m *SchemaMigrator) Rollback(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("rollback steps must be positive, got %d", steps)
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if steps > len(applied) {
+		return fmt.Errorf("cannot roll back %d migration(s); only %d applied", steps, len(applied))
+	}
+	return m.revertMigrations(ctx, applied[:steps])
+}
+
+// Repair records the current checksum, name and down SQL of every applied
+// migration in migrations, accepting intentional edits to their files.
+// Migrations that have not been applied are left alone.
+func (m *SchemaMigrator) Repair(ctx context.Context, migrations []*SchemaMigration) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.appliedChecksums(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if _, err := m.conn().ExecContext(ctx, `UPDATE `+schemaMigrationsTable+` SET checksum = $1, name = $2, down_sql = NULLIF($3, '') WHERE version = $4`,
+			migration.Checksum(), migration.Name, migration.DownSQL, migration.Version); err != nil {
+			return fmt.Errorf("repair migration %d: %w", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+// Status reports every migration in migrations or in the tracking table,
+// ordered by version. It only reads from the database: a missing tracking
+// table means every migration is pending.
+func (m *SchemaMigrator) Status(ctx context.Context, migrations []*SchemaMigration) ([]MigrationStatus, error) {
+	var exists bool
+	if err := m.conn().QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, schemaMigrationsTable).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]MigrationStatus)
+	checksums := make(map[int64]string)
+	if exists {
+		// checksum is read through to_jsonb so that tables created before the
+		// column was added, and not yet migrated, can still be reported.
+		rows, err := m.conn().QueryContext(ctx, `SELECT version, name, COALESCE(to_jsonb(t)->>'checksum', ''), applied_at FROM `+schemaMigrationsTable+` t`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var status MigrationStatus
+			var checksum string
+			if err := rows.Scan(&status.Version, &status.Name, &checksum, &status.AppliedAt); err != nil {
+				return nil, err
+			}
+			status.State = MigrationMissing
+			applied[status.Version] = status
+			checksums[status.Version] = checksum
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	report := make([]MigrationStatus, 0, len(migrations)+len(applied))
+	for _, migration := range migrations {
+		status, ok := applied[migration.Version]
+		delete(applied, migration.Version)
+		switch {
+		case !ok:
+			status = MigrationStatus{Version: migration.Version, Name: migration.Name, State: MigrationPending}
+		case checksums[migration.Version] != "" && checksums[migration.Version] != migration.Checksum():
+			status.State = MigrationChecksumMismatch
+		default:
+			status.State = MigrationApplied
+		}
+		status.File = migration.File
+		report = append(report, status)
+	}
+	for _, status := range applied {
+		report = append(report, status)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Version < report[j].Version })
+	return report, nil
+}
+
+// MigrateTo moves the schema to version: newer applied migrations are rolled
+// back and pending migrations up to version are applied. Version 0 reverts
+// every migration. Down SQL missing from the tracking table (for versions
+// applied by older releases) is taken from migrations.
+func (m *SchemaMigrator) MigrateTo(ctx context.Context, migrations []*SchemaMigration, version int64) error {
+	if version < 0 {
+		return fmt.Errorf("target version must not be negative, got %d", version)
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[int64]*SchemaMigration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	appliedSet := make(map[int64]bool, len(applied))
+	for _, migration := range applied {
+		appliedSet[migration.Version] = true
+	}
+	if version != 0 && known[version] == nil && !appliedSet[version] {
+		return fmt.Errorf("unknown target version %d", version)
+	}
+
+	var revert []*SchemaMigration
+	for _, migration := range applied {
+		if migration.Version <= version {
+			continue
+		}
+		if migration.DownSQL == "" && known[migration.Version] != nil {
+			migration.DownSQL = known[migration.Version].DownSQL
+		}
+		revert = append(revert, migration)
+	}
+	if err := m.revertMigrations(ctx, revert); err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.Version > version || appliedSet[migration.Version] {
+			continue
+		}
+		if err := m.applyMigration(ctx, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appliedMigrations returns the recorded migrations, newest first.
+func (m *SchemaMigrator) appliedMigrations(ctx context.Context) ([]*SchemaMigration, error) {
+	rows, err := m.conn().QueryContext(ctx, `SELECT version, name, COALESCE(down_sql, '') FROM `+schemaMigrationsTable+` ORDER BY version DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []*SchemaMigration
+	for rows.Next() {
+		migration := &SchemaMigration{}
+		if err := rows.Scan(&migration.Version, &migration.Name, &migration.DownSQL); err != nil {
+			return nil, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, rows.Err()
+}
+
+// revertMigrations reverts migrations in the given order after checking that
+// each one has down SQL, so a missing down section fails before any change.
+func (m *SchemaMigrator) revertMigrations(ctx context.Context, migrations []*SchemaMigration) error {
+	for _, migration := range migrations {
+		if migration.DownSQL == "" {
+			return fmt.Errorf("migration %d (%s) has no down SQL", migration.Version, migration.Name)
+		}
+	}
+	for _, migration := range migrations {
+		if err := m.revertMigration(ctx, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SchemaMigrator) revertMigration(ctx context.Context, migration *SchemaMigration) error {
+	if hasNoTransactionDirective(migration.DownSQL) && m.tx == nil {
+		if err := execStatements(ctx, m.db, migration.DownSQL); err != nil {
+			return fmt.Errorf("revert non-transactional migration %d (%s): %w; the version is still recorded as applied", migration.Version, migration.Name, err)
+		}
+		_, err := m.db.ExecContext(ctx, `DELETE FROM `+schemaMigrationsTable+` WHERE version = $1`, migration.Version)
+		return err
+	}
+	return withTx(ctx, m.db, m.tx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.DownSQL); err != nil {
+			return fmt.Errorf("revert migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM `+schemaMigrationsTable+` WHERE version = $1`, migration.Version)
+		return err
+	})
+}
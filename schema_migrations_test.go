@@ -2,9 +2,15 @@ package sqlproc
 
 import (
 	"context"
+	"errors"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
@@ -46,13 +52,13 @@ func TestSchemaMigratorMigrate(t *testing.T) {
 
 	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
 		WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectQuery("SELECT version FROM sqlproc_schema_migrations").
-		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(1)))
+	mock.ExpectQuery("SELECT version, COALESCE\\(checksum, ''\\) FROM sqlproc_schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}).AddRow(int64(1), migs[0].Checksum()))
 	mock.ExpectBegin()
 	mock.ExpectExec("CREATE INDEX idx ON test\\(id\\);").
 		WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec("INSERT INTO sqlproc_schema_migrations").
-		WithArgs(int64(2), "add_idx").
+		WithArgs(int64(2), "add_idx", "", migs[1].Checksum(), "", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -65,3 +71,282 @@ func TestSchemaMigratorMigrate(t *testing.T) {
 		t.Fatalf("unmet expectations: %v", err)
 	}
 }
+
+func TestLoadSchemaMigrationsDown(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		writeTestFile(t, dir, "001_init.up.sql", "CREATE TABLE test(id INT);"),
+		writeTestFile(t, dir, "001_init.down.sql", "DROP TABLE test;"),
+		writeTestFile(t, dir, "002_add_idx.sql", "-- +up\nCREATE INDEX idx ON test(id);\n\n-- +down\nDROP INDEX idx;\n"),
+		writeTestFile(t, dir, "003_func.sql", "CREATE FUNCTION f() RETURNS int AS $$\n-- +down\nSELECT 1;\n$$ LANGUAGE sql;"),
+	}
+	migs, err := LoadSchemaMigrations(files)
+	if err != nil {
+		t.Fatalf("LoadSchemaMigrations error: %v", err)
+	}
+	if len(migs) != 3 {
+		t.Fatalf("expected 3 migrations, got %d", len(migs))
+	}
+	if migs[0].Name != "init" || migs[0].SQL != "CREATE TABLE test(id INT);" || migs[0].DownSQL != "DROP TABLE test;" {
+		t.Fatalf("unexpected paired migration %+v", migs[0])
+	}
+	if migs[1].SQL != "CREATE INDEX idx ON test(id);" || migs[1].DownSQL != "DROP INDEX idx;" {
+		t.Fatalf("unexpected in-file down section %+v", migs[1])
+	}
+	if migs[2].DownSQL != "" {
+		t.Fatalf("-- +down inside a function body must be ignored, got %q", migs[2].DownSQL)
+	}
+
+	orphan := writeTestFile(t, t.TempDir(), "004_orphan.down.sql", "DROP TABLE x;")
+	if _, err := LoadSchemaMigrations([]string{orphan}); err == nil {
+		t.Fatal("expected error for down migration without up migration")
+	}
+}
+
+func TestSchemaMigratorRollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, name, COALESCE\\(down_sql, ''\\) FROM sqlproc_schema_migrations ORDER BY version DESC").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "down_sql"}).
+			AddRow(int64(2), "add_idx", "DROP INDEX idx;").
+			AddRow(int64(1), "init", "DROP TABLE test;"))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP INDEX idx;").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sqlproc_schema_migrations WHERE version = \\$1").
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := NewSchemaMigrator(db).Rollback(context.Background(), 1); err != nil {
+		t.Fatalf("Rollback error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSchemaMigratorMigrateTo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	migs := []*SchemaMigration{
+		{Version: 1, Name: "init", SQL: "CREATE TABLE test(id INT);", DownSQL: "DROP TABLE test;"},
+		{Version: 2, Name: "add_idx", SQL: "CREATE INDEX idx ON test(id);", DownSQL: "DROP INDEX idx;"},
+		{Version: 3, Name: "add_col", SQL: "ALTER TABLE test ADD COLUMN name TEXT;"},
+	}
+
+	// Version 3 was applied without down SQL recorded; the file supplies none
+	// either, so migrating below it must fail before touching the schema.
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, name, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "down_sql"}).
+			AddRow(int64(3), "add_col", "").
+			AddRow(int64(1), "init", ""))
+	if err := NewSchemaMigrator(db).MigrateTo(context.Background(), migs, 2); err == nil {
+		t.Fatal("expected error when rolling back a migration without down SQL")
+	}
+
+	// From version 2 to 1 uses the down SQL from the migration file, then
+	// nothing is pending at or below version 1.
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, name, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "down_sql"}).
+			AddRow(int64(2), "add_idx", "").
+			AddRow(int64(1), "init", "DROP TABLE test;"))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP INDEX idx;").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sqlproc_schema_migrations").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := NewSchemaMigrator(db).MigrateTo(context.Background(), migs, 1); err != nil {
+		t.Fatalf("MigrateTo(1) error: %v", err)
+	}
+
+	// From version 1 up to 3 applies the pending migrations in order.
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, name, COALESCE").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "down_sql"}).
+			AddRow(int64(1), "init", "DROP TABLE test;"))
+	for _, mig := range migs[1:] {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(mig.SQL)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO sqlproc_schema_migrations").
+			WithArgs(mig.Version, mig.Name, mig.DownSQL, mig.Checksum(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+	if err := NewSchemaMigrator(db).MigrateTo(context.Background(), migs, 3); err != nil {
+		t.Fatalf("MigrateTo(3) error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSchemaMigratorDrift(t *testing.T) {
+	migs := []*SchemaMigration{
+		{Version: 1, Name: "init", File: "001_init.sql", SQL: "CREATE TABLE test(id INT, name TEXT);"},
+	}
+	stale := (&SchemaMigration{SQL: "CREATE TABLE test(id INT);"}).Checksum()
+
+	expectApplied := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version, COALESCE").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}).AddRow(int64(1), stale))
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectApplied(mock)
+	err = NewSchemaMigrator(db).Migrate(context.Background(), migs)
+	if err == nil || !strings.Contains(err.Error(), "001_init.sql") {
+		t.Fatalf("expected drift error naming the file, got %v", err)
+	}
+
+	expectApplied(mock)
+	var logs strings.Builder
+	migrator := NewSchemaMigrator(db)
+	migrator.DriftPolicy = DriftWarn
+	migrator.Logger = log.New(&logs, "", 0)
+	if err := migrator.Migrate(context.Background(), migs); err != nil {
+		t.Fatalf("Migrate with DriftWarn error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "warning: applied schema migration(s) changed") {
+		t.Fatalf("expected drift warning, got %q", logs.String())
+	}
+
+	expectApplied(mock)
+	mock.ExpectExec("UPDATE sqlproc_schema_migrations SET checksum").
+		WithArgs(migs[0].Checksum(), "init", "", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := NewSchemaMigrator(db).Repair(context.Background(), migs); err != nil {
+		t.Fatalf("Repair error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSchemaMigratorStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	migs := []*SchemaMigration{
+		{Version: 1, Name: "init", File: "001_init.sql", SQL: "CREATE TABLE test(id INT);"},
+		{Version: 2, Name: "add_idx", File: "002_add_idx.sql", SQL: "CREATE INDEX idx ON test(id);"},
+		{Version: 4, Name: "add_col", File: "004_add_col.sql", SQL: "ALTER TABLE test ADD COLUMN name TEXT;"},
+	}
+	appliedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT to_regclass\\(\\$1\\) IS NOT NULL").WithArgs("sqlproc_schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT version, name, COALESCE\\(to_jsonb\\(t\\)->>'checksum', ''\\), applied_at FROM sqlproc_schema_migrations t").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+			AddRow(int64(1), "init", migs[0].Checksum(), appliedAt).
+			AddRow(int64(2), "add_idx", "stale", appliedAt).
+			AddRow(int64(3), "dropped", "", appliedAt))
+
+	report, err := NewSchemaMigrator(db).Status(context.Background(), migs)
+	if err != nil {
+		t.Fatalf("Status error: %v", err)
+	}
+	want := []MigrationStatus{
+		{Version: 1, Name: "init", File: "001_init.sql", State: MigrationApplied, AppliedAt: appliedAt},
+		{Version: 2, Name: "add_idx", File: "002_add_idx.sql", State: MigrationChecksumMismatch, AppliedAt: appliedAt},
+		{Version: 3, Name: "dropped", State: MigrationMissing, AppliedAt: appliedAt},
+		{Version: 4, Name: "add_col", File: "004_add_col.sql", State: MigrationPending},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("Status = %+v; want %+v", report, want)
+	}
+
+	// Without a tracking table nothing has been applied and nothing is created.
+	mock.ExpectQuery("SELECT to_regclass").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	report, err = NewSchemaMigrator(db).Status(context.Background(), migs[:1])
+	if err != nil {
+		t.Fatalf("Status without table error: %v", err)
+	}
+	if len(report) != 1 || report[0].State != MigrationPending {
+		t.Fatalf("expected a single pending migration, got %+v", report)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSchemaMigratorNoTransaction(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "002_concurrent_idx.sql", `-- sqlproc:no-transaction
+CREATE INDEX CONCURRENTLY idx_a ON test(a);
+CREATE INDEX CONCURRENTLY idx_b ON test(b);
+-- +down
+DROP INDEX idx_a;`)
+	migs, err := LoadSchemaMigrations([]string{file})
+	if err != nil {
+		t.Fatalf("LoadSchemaMigrations error: %v", err)
+	}
+	if !migs[0].NoTransaction {
+		t.Fatal("expected NoTransaction to be set by the directive")
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectPending := func() {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS sqlproc_schema_migrations").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version, COALESCE").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}))
+	}
+
+	// A failure partway stops before recording the version.
+	expectPending()
+	mock.ExpectExec("CREATE INDEX CONCURRENTLY idx_a").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX CONCURRENTLY idx_b").WillReturnError(errors.New("boom"))
+	err = NewSchemaMigrator(db).Migrate(context.Background(), migs)
+	if err == nil || !strings.Contains(err.Error(), "statement 2 of 2 failed after 1 succeeded") {
+		t.Fatalf("expected partial failure error, got %v", err)
+	}
+
+	// Each statement runs on its own, outside a transaction, then the
+	// version is recorded.
+	expectPending()
+	mock.ExpectExec("CREATE INDEX CONCURRENTLY idx_a").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX CONCURRENTLY idx_b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO sqlproc_schema_migrations").
+		WithArgs(int64(2), "concurrent_idx", "DROP INDEX idx_a;", migs[0].Checksum(), "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := NewSchemaMigrator(db).Migrate(context.Background(), migs); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
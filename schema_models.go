@@ -6,9 +6,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"go/format"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"text/template"
@@ -24,6 +24,21 @@ type SchemaModelOptions struct {
 	PackageName string
 	// StructTag defines struct tag keys, comma-separated (e.g. "db,json").
 	StructTag string
+	// NullStyle selects the Go representation of nullable columns. Defaults to pointers.
+	NullStyle NullStyle
+	// TypeOverrides replace the Go types chosen for database types or table columns.
+	TypeOverrides []TypeOverride
+	// SortColumns orders struct fields alphabetically. By default they follow
+	// the column order of the table, matching SELECT *.
+	SortColumns bool
+	// IncludeKinds limits introspection to these relation kinds. Empty => all.
+	IncludeKinds []RelationKind
+	// ExcludeKinds skips these relation kinds.
+	ExcludeKinds []RelationKind
+	// RelationHelpers adds a method per foreign key to the generated structs
+	// that returns the referenced row's key, when the referenced table is
+	// generated too.
+	RelationHelpers bool
 }
 
 func (o SchemaModelOptions) withDefaults(fallbackDir, fallbackPkg string) SchemaModelOptions {
@@ -42,11 +57,97 @@ func (o SchemaModelOptions) withDefaults(fallbackDir, fallbackPkg string) Schema
 	return o
 }
 
-// Table represents a database table discovered via introspection.
+// RelationKind classifies the relations schema introspection reads, after
+// pg_class.relkind.
+type RelationKind string
+
+const (
+	RelationTable            RelationKind = "table"
+	RelationView             RelationKind = "view"
+	RelationMaterializedView RelationKind = "materialized_view"
+	RelationForeignTable     RelationKind = "foreign_table"
+	// RelationPartitionedTable is the parent of a partitioned table. Its
+	// partitions are not introspected separately.
+	RelationPartitionedTable RelationKind = "partitioned_table"
+)
+
+// relkinds maps each RelationKind to its pg_class.relkind code.
+var relkinds = map[RelationKind]string{
+	RelationTable:            "r",
+	RelationView:             "v",
+	RelationMaterializedView: "m",
+	RelationForeignTable:     "f",
+	RelationPartitionedTable: "p",
+}
+
+func relationKindOf(relkind string) RelationKind {
+	for kind, code := range relkinds {
+		if code == relkind {
+			return kind
+		}
+	}
+	return RelationKind(relkind)
+}
+
+// kindFilter returns an " AND c.relkind IN (...)" clause for the kinds
+// left after applying include and exclude, in a stable order.
+func kindFilter(include, exclude []RelationKind) (string, error) {
+	for _, kind := range append(append([]RelationKind(nil), include...), exclude...) {
+		if _, ok := relkinds[kind]; !ok {
+			return "", fmt.Errorf("unknown relation kind %q (use table, view, materialized_view, foreign_table or partitioned_table)", kind)
+		}
+	}
+	var codes []string
+	for _, kind := range []RelationKind{RelationTable, RelationView, RelationMaterializedView, RelationForeignTable, RelationPartitionedTable} {
+		if (len(include) == 0 || slices.Contains(include, kind)) && !slices.Contains(exclude, kind) {
+			codes = append(codes, "'"+relkinds[kind]+"'")
+		}
+	}
+	if len(codes) == 0 {
+		return "", errors.New("every relation kind is excluded")
+	}
+	return " AND c.relkind IN (" + strings.Join(codes, ", ") + ")", nil
+}
+
+// Table represents a database table discovered via introspection. Views,
+// materialized views, foreign tables and partitioned tables are Tables too,
+// told apart by Kind.
 type Table struct {
-	Schema  string
-	Name    string
+	Schema string
+	Name   string
+	// Kind is the relation kind. The zero value behaves like RelationTable.
+	Kind    RelationKind
 	Columns []TableColumn
+	// Comment is the relation's COMMENT ON text.
+	Comment string
+	// PrimaryKey lists the primary key columns in key order.
+	PrimaryKey []string
+	// UniqueConstraints are the table's UNIQUE constraints.
+	UniqueConstraints []UniqueConstraint
+	// ForeignKeys are the table's FOREIGN KEY constraints.
+	ForeignKeys []ForeignKey
+}
+
+// ReadOnly reports whether rows cannot be written through the relation:
+// views and materialized views.
+func (t *Table) ReadOnly() bool {
+	return t.Kind == RelationView || t.Kind == RelationMaterializedView
+}
+
+// UniqueConstraint is a UNIQUE constraint and its columns in key order.
+type UniqueConstraint struct {
+	Name    string
+	Columns []string
+}
+
+// ForeignKey is a FOREIGN KEY constraint. Columns[i] references
+// RefColumns[i] of RefSchema.RefTable.
+type ForeignKey struct {
+	Name       string
+	Columns    []string
+	RefSchema  string
+	RefTable   string
+	RefColumns []string
 }
 
 // TableColumn represents a column in a table.
@@ -54,28 +155,50 @@ type TableColumn struct {
 	Name     string
 	DBType   string
 	Nullable bool
+	// Default is the column's default expression, or the GENERATED clause of
+	// an identity or generated column. Empty when the column has none.
+	Default string
+	// PrimaryKey reports whether the column is part of the primary key.
+	PrimaryKey bool
+	// Unique reports whether a single-column UNIQUE constraint covers the column.
+	Unique bool
+	// Comment is the column's COMMENT ON text.
+	Comment string
 }
 
 func loadSchemaTables(ctx context.Context, db *sql.DB, opts SchemaModelOptions) ([]*Table, error) {
+	kinds, err := kindFilter(opts.IncludeKinds, opts.ExcludeKinds)
+	if err != nil {
+		return nil, err
+	}
+	// pg_catalog rather than information_schema.columns, which omits
+	// materialized views. Domain columns report their base type.
 	queryBuilder := strings.Builder{}
 	queryBuilder.WriteString(`
-SELECT table_schema, table_name, column_name, data_type, udt_name, is_nullable
-FROM information_schema.columns
-WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`)
-
-	var args []any
-	if len(opts.Schemas) > 0 {
-		queryBuilder.WriteString(" AND table_schema IN (")
-		for i, schema := range opts.Schemas {
-			if i > 0 {
-				queryBuilder.WriteString(", ")
-			}
-			queryBuilder.WriteString(fmt.Sprintf("$%d", i+1))
-			args = append(args, schema)
-		}
-		queryBuilder.WriteString(")")
-	}
-	queryBuilder.WriteString(" ORDER BY table_schema, table_name, ordinal_position")
+SELECT n.nspname, c.relname, c.relkind, a.attname,
+	CASE WHEN t.typtype = 'd' THEN bt.typname ELSE t.typname END,
+	NOT a.attnotnull,
+	COALESCE(obj_description(c.oid, 'pg_class'), ''),
+	COALESCE(col_description(c.oid, a.attnum), ''),
+	CASE
+		WHEN a.attgenerated = 's' THEN 'GENERATED ALWAYS AS (' || pg_get_expr(ad.adbin, ad.adrelid) || ') STORED'
+		WHEN a.attidentity = 'a' THEN 'GENERATED ALWAYS AS IDENTITY'
+		WHEN a.attidentity = 'd' THEN 'GENERATED BY DEFAULT AS IDENTITY'
+		ELSE COALESCE(pg_get_expr(ad.adbin, ad.adrelid), '')
+	END
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_type t ON t.oid = a.atttypid
+LEFT JOIN pg_type bt ON bt.oid = t.typbasetype
+LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+WHERE a.attnum > 0 AND NOT a.attisdropped AND NOT c.relispartition
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema')`)
+
+	filter, args := schemaFilter("n.nspname", opts.Schemas)
+	queryBuilder.WriteString(kinds)
+	queryBuilder.WriteString(filter)
+	queryBuilder.WriteString(" ORDER BY n.nspname, c.relname, a.attnum")
 
 	rows, err := db.QueryContext(ctx, queryBuilder.String(), args...)
 	if err != nil {
@@ -84,18 +207,21 @@ WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`)
 	defer rows.Close()
 
 	type rawColumn struct {
-		schema    string
-		table     string
-		column    string
-		dataType  string
-		udtName   string
-		isNullStr string
+		schema   string
+		table    string
+		relkind  string
+		column   string
+		udtName  string
+		nullable bool
+		relDoc   string
+		colDoc   string
+		defValue string
 	}
 
 	var rawCols []rawColumn
 	for rows.Next() {
 		var rc rawColumn
-		if err := rows.Scan(&rc.schema, &rc.table, &rc.column, &rc.dataType, &rc.udtName, &rc.isNullStr); err != nil {
+		if err := rows.Scan(&rc.schema, &rc.table, &rc.relkind, &rc.column, &rc.udtName, &rc.nullable, &rc.relDoc, &rc.colDoc, &rc.defValue); err != nil {
 			return nil, err
 		}
 		rawCols = append(rawCols, rc)
@@ -112,14 +238,18 @@ WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`)
 			table = &Table{
 				Schema:  rc.schema,
 				Name:    rc.table,
+				Kind:    relationKindOf(rc.relkind),
+				Comment: rc.relDoc,
 				Columns: make([]TableColumn, 0),
 			}
 			tableMap[key] = table
 		}
 		table.Columns = append(table.Columns, TableColumn{
 			Name:     rc.column,
-			DBType:   pickDBType(rc.dataType, rc.udtName),
-			Nullable: strings.EqualFold(rc.isNullStr, "YES"),
+			DBType:   pickDBType("", rc.udtName),
+			Nullable: rc.nullable,
+			Default:  rc.defValue,
+			Comment:  rc.colDoc,
 		})
 	}
 
@@ -134,15 +264,119 @@ WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`)
 		return tables[i].Schema < tables[j].Schema
 	})
 
-	for _, table := range tables {
-		sort.Slice(table.Columns, func(i, j int) bool {
-			return table.Columns[i].Name < table.Columns[j].Name
-		})
+	if err := loadTableConstraints(ctx, db, opts.Schemas, tableMap); err != nil {
+		return nil, err
+	}
+
+	if opts.SortColumns {
+		for _, table := range tables {
+			sort.SliceStable(table.Columns, func(i, j int) bool {
+				return table.Columns[i].Name < table.Columns[j].Name
+			})
+		}
 	}
 
 	return tables, nil
 }
 
+// loadTableConstraints fills in the primary keys, UNIQUE constraints and
+// foreign keys of the tables, keyed by "schema.table".
+func loadTableConstraints(ctx context.Context, db *sql.DB, schemas []string, tables map[string]*Table) error {
+	queryBuilder := strings.Builder{}
+	queryBuilder.WriteString(`
+SELECT n.nspname, c.relname, con.conname, con.contype, a.attname,
+	COALESCE(rn.nspname, ''), COALESCE(rc.relname, ''), COALESCE(ra.attname, '')
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+CROSS JOIN LATERAL unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+LEFT JOIN pg_class rc ON rc.oid = con.confrelid
+LEFT JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+LEFT JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = con.confkey[k.ord]
+WHERE con.contype IN ('p', 'u', 'f') AND n.nspname NOT IN ('pg_catalog', 'information_schema')`)
+
+	filter, args := schemaFilter("n.nspname", schemas)
+	queryBuilder.WriteString(filter)
+	queryBuilder.WriteString(" ORDER BY n.nspname, c.relname, con.contype, con.conname, k.ord")
+
+	rows, err := db.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schema, table, name, kind, column, refSchema, refTable, refColumn string
+		if err := rows.Scan(&schema, &table, &name, &kind, &column, &refSchema, &refTable, &refColumn); err != nil {
+			return err
+		}
+		t := tables[schema+"."+table]
+		if t == nil {
+			continue
+		}
+		switch kind {
+		case "p":
+			t.PrimaryKey = append(t.PrimaryKey, column)
+			continue
+		case "f":
+			if n := len(t.ForeignKeys); n == 0 || t.ForeignKeys[n-1].Name != name {
+				t.ForeignKeys = append(t.ForeignKeys, ForeignKey{Name: name, RefSchema: refSchema, RefTable: refTable})
+			}
+			fk := &t.ForeignKeys[len(t.ForeignKeys)-1]
+			fk.Columns = append(fk.Columns, column)
+			fk.RefColumns = append(fk.RefColumns, refColumn)
+			continue
+		}
+		if n := len(t.UniqueConstraints); n == 0 || t.UniqueConstraints[n-1].Name != name {
+			t.UniqueConstraints = append(t.UniqueConstraints, UniqueConstraint{Name: name})
+		}
+		last := &t.UniqueConstraints[len(t.UniqueConstraints)-1]
+		last.Columns = append(last.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		markKeyColumns(t)
+	}
+	return nil
+}
+
+// markKeyColumns sets PrimaryKey and Unique on the columns of t from its
+// constraints.
+func markKeyColumns(t *Table) {
+	for i := range t.Columns {
+		col := &t.Columns[i]
+		for _, name := range t.PrimaryKey {
+			if name == col.Name {
+				col.PrimaryKey = true
+			}
+		}
+		for _, unique := range t.UniqueConstraints {
+			if len(unique.Columns) == 1 && unique.Columns[0] == col.Name {
+				col.Unique = true
+			}
+		}
+	}
+}
+
+// schemaFilter returns an " AND column IN (...)" clause restricting column
+// to schemas, with its arguments. It is empty when schemas is empty.
+func schemaFilter(column string, schemas []string) (string, []any) {
+	if len(schemas) == 0 {
+		return "", nil
+	}
+	placeholders := make([]string, len(schemas))
+	args := make([]any, len(schemas))
+	for i, schema := range schemas {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = schema
+	}
+	return " AND " + column + " IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
 func pickDBType(dataType, udtName string) string {
 	switch {
 	case udtName != "" && !strings.EqualFold(udtName, "null"):
@@ -157,6 +391,9 @@ func pickDBType(dataType, udtName string) string {
 // SchemaModelGenerator renders Go structs for database tables.
 type SchemaModelGenerator struct {
 	Options SchemaModelOptions
+	// Enums are enum types referenced by the tables; they are written to
+	// enums.go and used for columns of those types.
+	Enums []*Enum
 }
 
 // Generate writes schema-based Go models for the provided tables.
@@ -171,43 +408,77 @@ func (g *SchemaModelGenerator) Generate(tables []*Table) ([]string, error) {
 		return nil, fmt.Errorf("create schema output dir: %w", err)
 	}
 
-	data := buildSchemaTemplateData(tables, g.Options.PackageName, g.Options.StructTag)
+	if err := g.Options.NullStyle.validate(); err != nil {
+		return nil, err
+	}
+
+	overrides, err := compileTypeOverrides(g.Options.TypeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	types := newTypeMapper(g.Options.NullStyle, overrides, g.Enums)
+	data := buildSchemaTemplateData(tables, g.Options.PackageName, g.Options.StructTag, types, g.Options.RelationHelpers)
 	var buf bytes.Buffer
 	tmpl := template.Must(template.New("schema-models").Parse(schemaModelsTemplate))
 	if err := tmpl.Execute(&buf, data); err != nil {
 		return nil, err
 	}
-	formatted, err := format.Source(buf.Bytes())
-	if err != nil {
-		formatted = buf.Bytes()
-	}
+	formatted := formatGoFile(data.Package, types.imports(), buf.Bytes())
 
 	path := filepath.Join(g.Options.OutputDir, "schema_models.go")
 	if err := os.WriteFile(path, formatted, 0o644); err != nil {
 		return nil, fmt.Errorf("write schema models: %w", err)
 	}
-	return []string{path}, nil
+	files := []string{path}
+	if len(g.Enums) > 0 {
+		enumsPath, err := writeEnumsFile(g.Options.OutputDir, data.Package, g.Enums)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, enumsPath)
+	}
+	return files, nil
 }
 
 type schemaTemplateData struct {
-	Package  string
-	Tables   []schemaTemplateTable
-	UsesTime bool
+	Package string
+	Tables  []schemaTemplateTable
 }
 
 type schemaTemplateTable struct {
-	Name    string
-	Comment string
-	Columns []schemaTemplateColumn
+	Name      string
+	Comment   []string
+	Columns   []schemaTemplateColumn
+	Relations []schemaTemplateRelation
 }
 
-type schemaTemplateColumn struct {
+// schemaTemplateRelation is a method returning the key of the row a foreign
+// key references, as a value of the referenced table's struct.
+type schemaTemplateRelation struct {
+	Receiver string
+	Struct   string
+	Method   string
+	Target   string
+	Comment  string
+	// NilCheck is the condition under which a nullable key column leaves the
+	// reference unset; the method then also returns a bool.
+	NilCheck string
+	Fields   []schemaTemplateAssign
+}
+
+type schemaTemplateAssign struct {
 	Field string
-	Type  string
-	Tag   string
+	Value string
 }
 
-func buildSchemaTemplateData(tables []*Table, pkg, structTag string) schemaTemplateData {
+type schemaTemplateColumn struct {
+	Field   string
+	Type    string
+	Tag     string
+	Comment []string
+}
+
+func buildSchemaTemplateData(tables []*Table, pkg, structTag string, types *typeMapper, relations bool) schemaTemplateData {
 	if pkg == "" {
 		pkg = "generated"
 	}
@@ -219,28 +490,212 @@ func buildSchemaTemplateData(tables []*Table, pkg, structTag string) schemaTempl
 	}
 
 	for _, table := range tables {
+		name := goStructName(table.Schema, table.Name)
 		tmplTable := schemaTemplateTable{
-			Name:    goStructName(table.Schema, table.Name),
+			Name:    name,
+			Comment: tableComment(name, table),
 			Columns: make([]schemaTemplateColumn, 0, len(table.Columns)),
 		}
 		for _, col := range table.Columns {
-			goType := goTypeForColumn(col)
 			tmplCol := schemaTemplateColumn{
-				Field: toGoExportedField(col.Name),
-				Type:  goType,
-				Tag:   buildStructTag(tagKeys, col.Name),
-			}
-			if strings.Contains(goType, "time.Time") {
-				result.UsesTime = true
+				Field:   toGoExportedField(col.Name),
+				Type:    types.columnType(tableScopes(table), col.Name, col.DBType, col.Nullable),
+				Tag:     buildColumnTag(tagKeys, col),
+				Comment: columnComment(col),
 			}
 			tmplTable.Columns = append(tmplTable.Columns, tmplCol)
 		}
 		result.Tables = append(result.Tables, tmplTable)
 	}
 
+	if relations {
+		structs := make(map[string]*schemaTemplateTable, len(tables))
+		for i, table := range tables {
+			structs[table.Schema+"."+table.Name] = &result.Tables[i]
+		}
+		for i, table := range tables {
+			result.Tables[i].Relations = buildRelations(&result.Tables[i], table.ForeignKeys, structs)
+		}
+	}
+
 	return result
 }
 
+// buildRelations returns a key method for each foreign key of tmplTable
+// whose referenced table is generated and whose column types line up with
+// the referenced fields. structs maps "schema.table" to generated structs.
+func buildRelations(tmplTable *schemaTemplateTable, foreignKeys []ForeignKey, structs map[string]*schemaTemplateTable) []schemaTemplateRelation {
+	fieldTypes := func(t *schemaTemplateTable) map[string]string {
+		types := make(map[string]string, len(t.Columns))
+		for _, col := range t.Columns {
+			types[col.Field] = col.Type
+		}
+		return types
+	}
+	own := fieldTypes(tmplTable)
+	taken := make(map[string]bool, len(own))
+	for field := range own {
+		taken[field] = true
+	}
+
+	receiver := strings.ToLower(tmplTable.Name[:1])
+	var relations []schemaTemplateRelation
+	for _, fk := range foreignKeys {
+		target := structs[fk.RefSchema+"."+fk.RefTable]
+		if target == nil || len(fk.Columns) != len(fk.RefColumns) {
+			continue
+		}
+		targetTypes := fieldTypes(target)
+		relation := schemaTemplateRelation{
+			Receiver: receiver,
+			Struct:   tmplTable.Name,
+			Target:   target.Name,
+		}
+		var nilChecks []string
+		matched := true
+		for i, column := range fk.Columns {
+			field, refField := toGoExportedField(column), toGoExportedField(fk.RefColumns[i])
+			refType, ok := targetTypes[refField]
+			value := receiver + "." + field
+			switch {
+			case !ok:
+				matched = false
+			case own[field] == refType:
+			default:
+				var isNull string
+				value, isNull, ok = nullableKey(own[field], refType, value)
+				matched = matched && ok
+				nilChecks = append(nilChecks, isNull)
+			}
+			relation.Fields = append(relation.Fields, schemaTemplateAssign{Field: refField, Value: value})
+		}
+		if !matched {
+			continue
+		}
+		relation.Method = relationMethodName(fk, target.Name)
+		if taken[relation.Method] {
+			relation.Method = toGoName(fk.Name) + "Key"
+		}
+		if taken[relation.Method] {
+			continue
+		}
+		taken[relation.Method] = true
+		relation.NilCheck = strings.Join(nilChecks, " || ")
+		relation.Comment = fmt.Sprintf("%s returns the key of the %s row that %s references.", relation.Method, target.Name, fk.Name)
+		if relation.NilCheck != "" {
+			relation.Comment = strings.TrimSuffix(relation.Comment, ".") + ", or false when a key column is NULL."
+		}
+		relations = append(relations, relation)
+	}
+	return relations
+}
+
+// nullableKey unwraps value, a nullable field of type fieldType, into a key
+// of type keyType. It returns the unwrapped expression and the condition under
+// which value is NULL, for each NullStyle: *T, sql.Null[T] and the named
+// sql.Null* types.
+func nullableKey(fieldType, keyType, value string) (string, string, bool) {
+	switch {
+	case fieldType == "*"+keyType:
+		return "*" + value, value + " == nil", true
+	case fieldType == "sql.Null["+keyType+"]":
+		return value + ".V", "!" + value + ".Valid", true
+	case fieldType != "" && fieldType == sqlNullTypes[keyType]:
+		return value + "." + strings.TrimPrefix(fieldType, "sql.Null"), "!" + value + ".Valid", true
+	}
+	return "", "", false
+}
+
+// relationMethodName names a foreign key's method after its column without
+// an "_id" suffix ("user_id" => "UserKey"), or after the referenced struct
+// for composite keys.
+func relationMethodName(fk ForeignKey, refStruct string) string {
+	if len(fk.Columns) != 1 {
+		return refStruct + "Key"
+	}
+	column := fk.Columns[0]
+	if base, ok := strings.CutSuffix(strings.ToLower(column), "_id"); ok && base != "" {
+		column = base
+	}
+	return toGoName(column) + "Key"
+}
+
+// tableComment returns the doc comment lines of a table's struct.
+func tableComment(name string, table *Table) []string {
+	kind := table.Kind
+	if kind == "" {
+		kind = RelationTable
+	}
+	lines := []string{fmt.Sprintf("%s is a row of the %s.%s %s.", name, table.Schema, table.Name, strings.ReplaceAll(string(kind), "_", " "))}
+	if comment := docLines(table.Comment); len(comment) > 0 {
+		lines = append(append(append(lines, ""), comment...), "")
+	}
+	if table.ReadOnly() {
+		lines = append(lines, "It is read-only.")
+	}
+	if len(table.PrimaryKey) > 0 {
+		lines = append(lines, fmt.Sprintf("Primary key: (%s).", strings.Join(table.PrimaryKey, ", ")))
+	}
+	for _, unique := range table.UniqueConstraints {
+		lines = append(lines, fmt.Sprintf("Unique %s: (%s).", unique.Name, strings.Join(unique.Columns, ", ")))
+	}
+	for _, fk := range table.ForeignKeys {
+		lines = append(lines, fmt.Sprintf("Foreign key %s: (%s) references %s.%s (%s).",
+			fk.Name, strings.Join(fk.Columns, ", "), fk.RefSchema, fk.RefTable, strings.Join(fk.RefColumns, ", ")))
+	}
+	return lines
+}
+
+// columnComment returns the doc comment lines of a column: its COMMENT ON
+// text followed by its key membership and default.
+func columnComment(col TableColumn) []string {
+	lines := docLines(col.Comment)
+	if meta := columnMetadata(col); meta != "" {
+		lines = append(lines, meta)
+	}
+	return lines
+}
+
+// columnMetadata describes a column's key membership and default, or
+// returns "" when there is nothing to say.
+func columnMetadata(col TableColumn) string {
+	var parts []string
+	if col.PrimaryKey {
+		parts = append(parts, "Primary key.")
+	}
+	if col.Unique {
+		parts = append(parts, "Unique.")
+	}
+	if col.Default != "" {
+		parts = append(parts, "Default: "+strings.Join(strings.Fields(col.Default), " ")+".")
+	}
+	return strings.Join(parts, " ")
+}
+
+// buildColumnTag extends buildStructTag with a sqlproc key listing the
+// column's "pk", "unique" and "default" properties.
+func buildColumnTag(keys []string, col TableColumn) string {
+	tag := buildStructTag(keys, col.Name)
+	var options []string
+	if col.PrimaryKey {
+		options = append(options, "pk")
+	}
+	if col.Unique {
+		options = append(options, "unique")
+	}
+	if col.Default != "" {
+		options = append(options, "default")
+	}
+	if len(options) == 0 {
+		return tag
+	}
+	extra := fmt.Sprintf(`sqlproc:"%s"`, strings.Join(options, ","))
+	if tag == "" {
+		return "`" + extra + "`"
+	}
+	return strings.TrimSuffix(tag, "`") + " " + extra + "`"
+}
+
 func parseTagKeys(spec string) []string {
 	if spec == "" {
 		return nil
@@ -278,29 +733,30 @@ func goStructName(schema, table string) string {
 	return toGoName(schema + "_" + table)
 }
 
-func goTypeForColumn(col TableColumn) string {
-	base := sqlTypeToGo(col.DBType)
-	if !col.Nullable {
-		return base
-	}
-	if strings.HasPrefix(base, "[]") {
-		return base
-	}
-	return "*" + base
-}
-
-const schemaModelsTemplate = `package {{ .Package }}
-
-{{- if .UsesTime }}
-import "time"
+const schemaModelsTemplate = `{{ range .Tables }}
+{{- range .Comment }}
+//{{ if . }} {{ . }}{{ end }}
 {{- end }}
-
-{{ range .Tables }}
 type {{ .Name }} struct {
 {{- range .Columns }}
+{{- range .Comment }}
+	//{{ if . }} {{ . }}{{ end }}
+{{- end }}
 	{{ .Field }} {{ .Type }}{{ if .Tag }} {{ .Tag }}{{ end }}
 {{- end }}
 }
+{{- range .Relations }}
+
+// {{ .Comment }}
+func ({{ .Receiver }} {{ .Struct }}) {{ .Method }}() {{ if .NilCheck }}({{ .Target }}, bool){{ else }}{{ .Target }}{{ end }} {
+{{- if .NilCheck }}
+	if {{ .NilCheck }} {
+		return {{ .Target }}{}, false
+	}
+{{- end }}
+	return {{ .Target }}{ {{- range $i, $f := .Fields }}{{ if $i }}, {{ end }}{{ $f.Field }}: {{ $f.Value }}{{ end }} }{{ if .NilCheck }}, true{{ end }}
+}
+{{- end }}
 
 {{ end }}
 `
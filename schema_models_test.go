@@ -1,9 +1,14 @@
 package sqlproc
 
 import (
+	"context"
 	"os"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
 )
 
 func TestSchemaModelGenerator_WritesStructs(t *testing.T) {
@@ -63,7 +68,7 @@ func TestBuildStructTag(t *testing.T) {
 	}
 }
 
-func TestGoTypeForColumn(t *testing.T) {
+func TestTypeMapperNullablePointers(t *testing.T) {
 	cases := []struct {
 		col  TableColumn
 		want string
@@ -71,10 +76,321 @@ func TestGoTypeForColumn(t *testing.T) {
 		{TableColumn{Name: "id", DBType: "int4", Nullable: false}, "int32"},
 		{TableColumn{Name: "payload", DBType: "jsonb", Nullable: true}, "[]byte"},
 		{TableColumn{Name: "published_at", DBType: "timestamp", Nullable: true}, "*time.Time"},
+		{TableColumn{Name: "tag_ids", DBType: pickDBType("ARRAY", "_int4"), Nullable: true}, "[]int32"},
 	}
 	for _, c := range cases {
-		if got := goTypeForColumn(c.col); got != c.want {
-			t.Fatalf("goTypeForColumn(%v) = %s, want %s", c.col, got, c.want)
+		if got := newTypeMapper(NullStylePointer, nil, nil).goType(c.col.DBType, c.col.Nullable); got != c.want {
+			t.Fatalf("goType(%v) = %s, want %s", c.col, got, c.want)
+		}
+	}
+}
+
+// attributeColumns are the columns of the loadSchemaTables query.
+var attributeColumns = []string{"nspname", "relname", "relkind", "attname", "typname", "nullable", "rel_comment", "col_comment", "default"}
+
+// constraintColumns are the columns of the loadTableConstraints query.
+var constraintColumns = []string{"nspname", "relname", "conname", "contype", "attname", "ref_schema", "ref_table", "ref_column"}
+
+func TestLoadSchemaTables_KeepsColumnOrderAndKeys(t *testing.T) {
+	t.Parallel()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM pg_attribute a").WithArgs("public").WillReturnRows(
+		sqlmock.NewRows(attributeColumns).
+			AddRow("public", "users", "r", "id", "int8", false, "Registered users.", "Stable user identifier.", "GENERATED BY DEFAULT AS IDENTITY").
+			AddRow("public", "users", "r", "org_id", "int4", false, "", "", "").
+			AddRow("public", "users", "r", "email", "text", false, "", "", "").
+			AddRow("public", "users", "r", "slug", "text", false, "", "", "").
+			AddRow("public", "users", "r", "created_at", "timestamptz", false, "", "", "now()"))
+	mock.ExpectQuery("FROM pg_constraint con").WithArgs("public").WillReturnRows(
+		sqlmock.NewRows(constraintColumns).
+			AddRow("public", "users", "users_pkey", "p", "id", "", "", "").
+			AddRow("public", "users", "users_email_key", "u", "email", "", "", "").
+			AddRow("public", "users", "users_org_id_slug_key", "u", "org_id", "", "", "").
+			AddRow("public", "users", "users_org_id_slug_key", "u", "slug", "", "", "").
+			AddRow("public", "users", "users_org_id_fkey", "f", "org_id", "public", "orgs", "id"))
+
+	tables, err := loadSchemaTables(context.Background(), db, SchemaModelOptions{Schemas: []string{"public"}})
+	if err != nil {
+		t.Fatalf("loadSchemaTables: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(tables))
+	}
+	users := tables[0]
+	var names []string
+	for _, col := range users.Columns {
+		names = append(names, col.Name)
+	}
+	if want := []string{"id", "org_id", "email", "slug", "created_at"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("columns = %v, want ordinal order %v", names, want)
+	}
+	if users.Comment != "Registered users." || users.Columns[0].Comment != "Stable user identifier." {
+		t.Fatalf("unexpected comments %q, %q", users.Comment, users.Columns[0].Comment)
+	}
+	if !reflect.DeepEqual(users.PrimaryKey, []string{"id"}) {
+		t.Fatalf("primary key = %v", users.PrimaryKey)
+	}
+	wantUnique := []UniqueConstraint{
+		{Name: "users_email_key", Columns: []string{"email"}},
+		{Name: "users_org_id_slug_key", Columns: []string{"org_id", "slug"}},
+	}
+	if !reflect.DeepEqual(users.UniqueConstraints, wantUnique) {
+		t.Fatalf("unique constraints = %+v", users.UniqueConstraints)
+	}
+	wantFKs := []ForeignKey{{Name: "users_org_id_fkey", Columns: []string{"org_id"}, RefSchema: "public", RefTable: "orgs", RefColumns: []string{"id"}}}
+	if !reflect.DeepEqual(users.ForeignKeys, wantFKs) {
+		t.Fatalf("foreign keys = %+v", users.ForeignKeys)
+	}
+	if !users.Columns[0].PrimaryKey || !users.Columns[2].Unique || users.Columns[1].Unique {
+		t.Fatalf("unexpected key flags: %+v", users.Columns)
+	}
+}
+
+func TestSchemaModelGenerator_KeyMetadata(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tables := []*Table{
+		{
+			Schema: "public",
+			Name:   "users",
+			Columns: []TableColumn{
+				{Name: "id", DBType: "int4", PrimaryKey: true, Default: "nextval('users_id_seq'::regclass)"},
+				{Name: "email", DBType: "text", Unique: true},
+				{Name: "name", DBType: "text"},
+			},
+			PrimaryKey:        []string{"id"},
+			UniqueConstraints: []UniqueConstraint{{Name: "users_email_key", Columns: []string{"email"}}},
+		},
+	}
+
+	gen := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "models", StructTag: "db"}}
+	files, err := gen.Generate(tables)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	src := string(content)
+	for _, want := range []string{
+		"// Users is a row of the public.users table.\n// Primary key: (id).\n// Unique users_email_key: (email).\ntype Users struct",
+		"// Primary key. Default: nextval('users_id_seq'::regclass).\n\tId int32 `db:\"id\" sqlproc:\"pk,default\"`",
+		"// Unique.\n\tEmail string `db:\"email\" sqlproc:\"unique\"`",
+		"\tName  string `db:\"name\"`\n}",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in output:\n%s", want, src)
+		}
+	}
+	if strings.Index(src, "Id ") > strings.Index(src, "Email ") {
+		t.Fatalf("expected fields in column order:\n%s", src)
+	}
+}
+
+func TestSchemaModelGenerator_RelationHelpers(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tables := []*Table{
+		{
+			Schema: "public",
+			Name:   "orders",
+			Columns: []TableColumn{
+				{Name: "id", DBType: "int4", PrimaryKey: true},
+				{Name: "user_id", DBType: "int4"},
+				{Name: "coupon_code", DBType: "text", Nullable: true},
+				{Name: "region", DBType: "text"},
+			},
+			PrimaryKey: []string{"id"},
+			ForeignKeys: []ForeignKey{
+				{Name: "orders_user_id_fkey", Columns: []string{"user_id"}, RefSchema: "public", RefTable: "users", RefColumns: []string{"id"}},
+				{Name: "orders_coupon_code_fkey", Columns: []string{"coupon_code"}, RefSchema: "public", RefTable: "coupons", RefColumns: []string{"code"}},
+				{Name: "orders_region_fkey", Columns: []string{"region"}, RefSchema: "geo", RefTable: "regions", RefColumns: []string{"code"}},
+			},
+		},
+		{
+			Schema:     "public",
+			Name:       "users",
+			Columns:    []TableColumn{{Name: "id", DBType: "int4", PrimaryKey: true}},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Schema:     "public",
+			Name:       "coupons",
+			Columns:    []TableColumn{{Name: "code", DBType: "text", PrimaryKey: true}},
+			PrimaryKey: []string{"code"},
+		},
+	}
+
+	gen := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "models", StructTag: "db", RelationHelpers: true}}
+	files, err := gen.Generate(tables)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	src := string(content)
+	for _, want := range []string{
+		"// Foreign key orders_user_id_fkey: (user_id) references public.users (id).",
+		"// UserKey returns the key of the Users row that orders_user_id_fkey references.\nfunc (o Orders) UserKey() Users {\n\treturn Users{Id: o.UserId}\n}",
+		"func (o Orders) CouponCodeKey() (Coupons, bool) {\n\tif o.CouponCode == nil {\n\t\treturn Coupons{}, false\n\t}\n\treturn Coupons{Code: *o.CouponCode}, true\n}",
+		"// Foreign key orders_region_fkey: (region) references geo.regions (code).",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in output:\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "RegionKey") {
+		t.Fatalf("expected no helper for a table that is not generated:\n%s", src)
+	}
+}
+
+func TestSchemaModelGenerator_RelationHelpersNullStyles(t *testing.T) {
+	t.Parallel()
+	tables := []*Table{
+		{
+			Schema:     "public",
+			Name:       "users",
+			Columns:    []TableColumn{{Name: "id", DBType: "int4", PrimaryKey: true}},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Schema:      "public",
+			Name:        "orders",
+			Columns:     []TableColumn{{Name: "id", DBType: "int4", PrimaryKey: true}, {Name: "user_id", DBType: "int4", Nullable: true}},
+			PrimaryKey:  []string{"id"},
+			ForeignKeys: []ForeignKey{{Name: "orders_user_id_fkey", Columns: []string{"user_id"}, RefSchema: "public", RefTable: "users", RefColumns: []string{"id"}}},
+		},
+	}
+	cases := map[NullStyle]string{
+		NullStyleSQL:     "func (o Orders) UserKey() (Users, bool) {\n\tif !o.UserId.Valid {\n\t\treturn Users{}, false\n\t}\n\treturn Users{Id: o.UserId.Int32}, true\n}",
+		NullStyleGeneric: "func (o Orders) UserKey() (Users, bool) {\n\tif !o.UserId.Valid {\n\t\treturn Users{}, false\n\t}\n\treturn Users{Id: o.UserId.V}, true\n}",
+	}
+	for style, want := range cases {
+		dir := t.TempDir()
+		gen := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "models", NullStyle: style, RelationHelpers: true}}
+		files, err := gen.Generate(tables)
+		if err != nil {
+			t.Fatalf("%s: generate: %v", style, err)
+		}
+		content, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatalf("%s: read file: %v", style, err)
+		}
+		if !strings.Contains(string(content), want) {
+			t.Fatalf("%s: expected %q in output:\n%s", style, want, content)
+		}
+		typeCheckDir(t, dir)
+	}
+}
+
+func TestLoadSchemaTables_RelationKinds(t *testing.T) {
+	t.Parallel()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("AND c.relkind IN ('r', 'v', 'm') ORDER BY")).WillReturnRows(
+		sqlmock.NewRows(attributeColumns).
+			AddRow("public", "active_users", "v", "id", "int4", true, "", "", "").
+			AddRow("public", "user_totals", "m", "total", "int8", true, "", "", "").
+			AddRow("public", "users", "r", "id", "int4", false, "", "", ""))
+	mock.ExpectQuery("FROM pg_constraint con").WillReturnRows(sqlmock.NewRows(constraintColumns))
+
+	tables, err := loadSchemaTables(context.Background(), db, SchemaModelOptions{
+		ExcludeKinds: []RelationKind{RelationForeignTable, RelationPartitionedTable},
+	})
+	if err != nil {
+		t.Fatalf("loadSchemaTables: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	kinds := map[string]RelationKind{}
+	for _, table := range tables {
+		kinds[table.Name] = table.Kind
+	}
+	want := map[string]RelationKind{"active_users": RelationView, "user_totals": RelationMaterializedView, "users": RelationTable}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+	if !tables[0].ReadOnly() || !tables[1].ReadOnly() || tables[2].ReadOnly() {
+		t.Fatalf("unexpected ReadOnly results for %+v", tables)
+	}
+}
+
+func TestKindFilter(t *testing.T) {
+	if got, err := kindFilter([]RelationKind{RelationView, RelationTable}, nil); err != nil || got != " AND c.relkind IN ('r', 'v')" {
+		t.Fatalf("kindFilter include = %q, %v", got, err)
+	}
+	if _, err := kindFilter([]RelationKind{"index"}, nil); err == nil || !strings.Contains(err.Error(), `unknown relation kind "index"`) {
+		t.Fatalf("expected unknown kind error, got %v", err)
+	}
+	if _, err := kindFilter([]RelationKind{RelationView}, []RelationKind{RelationView}); err == nil {
+		t.Fatal("expected an error when every kind is excluded")
+	}
+}
+
+func TestSchemaModelGenerator_ReadOnlyViews(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tables := []*Table{{Schema: "public", Name: "active_users", Kind: RelationView, Columns: []TableColumn{{Name: "id", DBType: "int4"}}}}
+	gen := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "models", StructTag: "db"}}
+	files, err := gen.Generate(tables)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if want := "// ActiveUsers is a row of the public.active_users view.\n// It is read-only.\ntype ActiveUsers struct"; !strings.Contains(string(content), want) {
+		t.Fatalf("expected %q in output:\n%s", want, content)
+	}
+}
+
+func TestSchemaModelGenerator_Comments(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tables := []*Table{{
+		Schema:     "public",
+		Name:       "users",
+		Comment:    "Registered users.\nOne row per account.",
+		PrimaryKey: []string{"id"},
+		Columns: []TableColumn{
+			{Name: "id", DBType: "int4", PrimaryKey: true, Comment: "Stable user identifier."},
+			{Name: "email", DBType: "text", Comment: "Login address,  \nlowercased."},
+		},
+	}}
+	gen := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "models", StructTag: "db"}}
+	files, err := gen.Generate(tables)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	src := string(content)
+	for _, want := range []string{
+		"// Users is a row of the public.users table.\n//\n// Registered users.\n// One row per account.\n//\n// Primary key: (id).\ntype Users struct {",
+		"\t// Stable user identifier.\n\t// Primary key.\n\tId int32",
+		"\t// Login address,\n\t// lowercased.\n\tEmail string",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in output:\n%s", want, src)
 		}
 	}
 }
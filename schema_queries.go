@@ -0,0 +1,150 @@
+package sqlproc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// tableProcedures describes the CRUD methods generated for each table as
+// procedures whose statements sqlproc writes itself. Tables without a primary
+// key only get List and Insert methods. Views only get List, and materialized
+// views List and Refresh.
+func tableProcedures(tables []*Table) []*Procedure {
+	var procs []*Procedure
+	for _, table := range tables {
+		if len(table.Columns) == 0 {
+			continue
+		}
+		name := goStructName(table.Schema, table.Name)
+		relation := pq.QuoteIdentifier(table.Schema) + "." + pq.QuoteIdentifier(table.Name)
+		returns := make([]Column, 0, len(table.Columns))
+		selectList := make([]string, 0, len(table.Columns))
+		var keys, inserts, updates []TableColumn
+		for _, col := range table.Columns {
+			returns = append(returns, Column{Name: col.Name, DBType: col.DBType, Nullable: col.Nullable})
+			selectList = append(selectList, pq.QuoteIdentifier(col.Name))
+			switch {
+			case col.PrimaryKey:
+				keys = append(keys, col)
+			case !isGeneratedAlways(col):
+				updates = append(updates, col)
+			}
+			if !isAutoGenerated(col) {
+				inserts = append(inserts, col)
+			}
+		}
+		columns := strings.Join(selectList, ", ")
+		newProc := func(method string, kind ReturnKind, params []TableColumn, query string) *Procedure {
+			proc := &Procedure{
+				Name:       method,
+				SQLName:    table.Schema + "." + table.Name,
+				Kind:       kind,
+				Params:     columnParams(params),
+				ReturnType: table.Schema + "." + table.Name,
+				query:      query,
+			}
+			if kind != ReturnExec {
+				proc.Returns = append([]Column(nil), returns...)
+			}
+			return proc
+		}
+
+		if len(keys) > 0 {
+			procs = append(procs, newProc("Get"+name+"ByPK", ReturnOne, keys,
+				fmt.Sprintf("SELECT %s FROM %s WHERE %s", columns, relation, keyCondition(keys))))
+		}
+		list := fmt.Sprintf("SELECT %s FROM %s", columns, relation)
+		if len(keys) > 0 {
+			list += " ORDER BY " + strings.Join(quotedColumns(keys), ", ")
+		}
+		procs = append(procs, newProc("List"+name, ReturnMany, nil, list))
+
+		if table.Kind == RelationMaterializedView {
+			refresh := newProc("Refresh"+name, ReturnExec, nil, "REFRESH MATERIALIZED VIEW "+relation)
+			refresh.Params = []Param{{Name: "concurrently", DBType: "boolean"}}
+			refresh.refresh = true
+			procs = append(procs, refresh)
+		}
+		if table.ReadOnly() {
+			continue
+		}
+
+		insert := fmt.Sprintf("INSERT INTO %s DEFAULT VALUES RETURNING %s", relation, columns)
+		if len(inserts) > 0 {
+			insert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+				relation, strings.Join(quotedColumns(inserts), ", "), placeholders(len(inserts)), columns)
+		}
+		insertProc := newProc("Insert"+name, ReturnOne, inserts, insert)
+		if len(inserts) > 1 {
+			insertProc.Options = []string{OptionParamsStruct}
+		}
+		procs = append(procs, insertProc)
+
+		if len(keys) == 0 {
+			continue
+		}
+		if len(updates) > 0 {
+			assignments := make([]string, 0, len(updates))
+			for i, col := range updates {
+				assignments = append(assignments, fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(col.Name), len(keys)+i+1))
+			}
+			update := newProc("Update"+name, ReturnOne, append(append([]TableColumn(nil), keys...), updates...),
+				fmt.Sprintf("UPDATE %s SET %s WHERE %s RETURNING %s", relation, strings.Join(assignments, ", "), keyCondition(keys), columns))
+			update.Options = []string{OptionParamsStruct}
+			procs = append(procs, update)
+		}
+		procs = append(procs, newProc("Delete"+name, ReturnExec, keys,
+			fmt.Sprintf("DELETE FROM %s WHERE %s", relation, keyCondition(keys))))
+	}
+	return procs
+}
+
+// isAutoGenerated reports whether the database fills col on insert: serial
+// and identity columns and generated columns. Insert methods leave them out;
+// columns with other defaults are set like any other.
+func isAutoGenerated(col TableColumn) bool {
+	upper := strings.ToUpper(col.Default)
+	return strings.HasPrefix(upper, "GENERATED ") || strings.HasPrefix(upper, "NEXTVAL(")
+}
+
+// isGeneratedAlways reports whether PostgreSQL rejects explicit values for
+// col: GENERATED ALWAYS identity and generated columns.
+func isGeneratedAlways(col TableColumn) bool {
+	return strings.HasPrefix(strings.ToUpper(col.Default), "GENERATED ALWAYS")
+}
+
+func columnParams(columns []TableColumn) []Param {
+	params := make([]Param, 0, len(columns))
+	for _, col := range columns {
+		params = append(params, Param{Name: col.Name, DBType: col.DBType, Nullable: col.Nullable})
+	}
+	return params
+}
+
+func quotedColumns(columns []TableColumn) []string {
+	quoted := make([]string, 0, len(columns))
+	for _, col := range columns {
+		quoted = append(quoted, pq.QuoteIdentifier(col.Name))
+	}
+	return quoted
+}
+
+// keyCondition matches columns against placeholders numbered from $1.
+func keyCondition(columns []TableColumn) string {
+	parts := make([]string, 0, len(columns))
+	for i, col := range columns {
+		parts = append(parts, fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(col.Name), i+1))
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// placeholders returns "$1, ..., $n".
+func placeholders(n int) string {
+	parts := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		parts = append(parts, fmt.Sprintf("$%d", i))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,221 @@
+package sqlproc
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func schemaQueryTables() []*Table {
+	return []*Table{
+		{
+			Schema: "public",
+			Name:   "users",
+			Columns: []TableColumn{
+				{Name: "id", DBType: "int4", PrimaryKey: true, Default: "nextval('users_id_seq'::regclass)"},
+				{Name: "email", DBType: "text", Unique: true},
+				{Name: "bio", DBType: "text", Nullable: true},
+				{Name: "type", DBType: "text"},
+				{Name: "search", DBType: "tsvector", Default: "GENERATED ALWAYS AS (to_tsvector('simple', email)) STORED"},
+			},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Schema:  "audit",
+			Name:    "events",
+			Columns: []TableColumn{{Name: "payload", DBType: "jsonb"}},
+		},
+	}
+}
+
+func TestCodeGeneratorSchemaQueries(t *testing.T) {
+	dir := t.TempDir()
+	cg := &CodeGenerator{OutputDir: dir, PackageName: "db", SchemaTables: schemaQueryTables(), SchemaQueries: true}
+	if err := cg.Generate(nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), name, content, 0); err != nil {
+			t.Fatalf("%s is not valid Go: %v\n%s", name, err, content)
+		}
+		return string(content)
+	}
+	queries := read("schema_queries.go")
+	for _, want := range []string{
+		`"SELECT \"id\", \"email\", \"bio\", \"type\", \"search\" FROM \"public\".\"users\" WHERE \"id\" = $1"`,
+		"func (q *Queries) GetUsersByPK(ctx context.Context, id int32) (GetUsersByPKRow, error)",
+		`ORDER BY \"id\""`,
+		"func (q *Queries) ListUsers(ctx context.Context) ([]ListUsersRow, error)",
+		`"INSERT INTO \"public\".\"users\" (\"email\", \"bio\", \"type\") VALUES ($1, $2, $3) RETURNING`,
+		"func (q *Queries) InsertUsers(ctx context.Context, arg InsertUsersParams) (InsertUsersRow, error)",
+		`"UPDATE \"public\".\"users\" SET \"email\" = $2, \"bio\" = $3, \"type\" = $4 WHERE \"id\" = $1 RETURNING`,
+		"q.db.QueryRowContext(ctx, query, arg.Id, arg.Email, arg.Bio, arg.Type)",
+		`"DELETE FROM \"public\".\"users\" WHERE \"id\" = $1"`,
+		"func (q *Queries) DeleteUsers(ctx context.Context, id int32) error",
+		"func (q *Queries) ListAuditEvents(ctx context.Context) ([]ListAuditEventsRow, error)",
+		"func (q *Queries) InsertAuditEvents(ctx context.Context, payload []byte) (InsertAuditEventsRow, error)",
+	} {
+		if !strings.Contains(queries, want) {
+			t.Fatalf("expected %q in schema_queries.go:\n%s", want, queries)
+		}
+	}
+	for _, unwanted := range []string{"GetAuditEventsByPK", "DeleteAuditEvents", `\"search\" = `} {
+		if strings.Contains(queries, unwanted) {
+			t.Fatalf("did not expect %q in schema_queries.go:\n%s", unwanted, queries)
+		}
+	}
+
+	models := read("models.go")
+	for _, want := range []string{"type GetUsersByPKRow = Users", "Bio   *string `json:\"bio\"`", "type UpdateUsersParams struct"} {
+		if !strings.Contains(models, want) {
+			t.Fatalf("expected %q in models.go:\n%s", want, models)
+		}
+	}
+	if db := read("db.go"); !strings.Contains(db, "DeleteUsers(ctx context.Context, id int32) error") {
+		t.Fatalf("expected table queries in Querier:\n%s", db)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "queries.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected no queries.go without procedures, got %v", err)
+	}
+}
+
+func TestCodeGeneratorSchemaQueriesNameCollision(t *testing.T) {
+	cg := &CodeGenerator{OutputDir: t.TempDir(), SchemaTables: schemaQueryTables(), SchemaQueries: true}
+	procs := []*Procedure{{Name: "ListUsers", SQLName: "list_users", File: "users.sql", Kind: ReturnExec}}
+	err := cg.Generate(procs)
+	if err == nil || !strings.Contains(err.Error(), "schema query ListUsers for table public.users collides with procedure ListUsers (users.sql)") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+}
+
+func TestGoParamNameAvoidsKeywordsAndLocals(t *testing.T) {
+	cases := map[string]string{"type": "typeArg", "query": "queryArg", "user_id": "userId", "func": "funcArg"}
+	for name, want := range cases {
+		if got := goParamName(name); got != want {
+			t.Fatalf("goParamName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCodeGeneratorSchemaQueriesViews(t *testing.T) {
+	dir := t.TempDir()
+	tables := []*Table{
+		{Schema: "public", Name: "active_users", Kind: RelationView, Columns: []TableColumn{{Name: "id", DBType: "int4"}}},
+		{Schema: "public", Name: "user_totals", Kind: RelationMaterializedView, Columns: []TableColumn{{Name: "total", DBType: "int4"}}},
+	}
+	cg := &CodeGenerator{OutputDir: dir, PackageName: "db", SchemaTables: tables, SchemaQueries: true, EmitMock: true}
+	if err := cg.Generate(nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "schema_queries.go"))
+	if err != nil {
+		t.Fatalf("read schema_queries.go: %v", err)
+	}
+	queries := string(content)
+	for _, want := range []string{
+		"func (q *Queries) ListActiveUsers(ctx context.Context) ([]ListActiveUsersRow, error)",
+		"func (q *Queries) ListUserTotals(ctx context.Context) ([]ListUserTotalsRow, error)",
+		"func (q *Queries) RefreshUserTotals(ctx context.Context, concurrently bool) error {\n\tquery := \"REFRESH MATERIALIZED VIEW \\\"public\\\".\\\"user_totals\\\"\"\n" +
+			"\tif concurrently {\n\t\tquery = \"REFRESH MATERIALIZED VIEW CONCURRENTLY \\\"public\\\".\\\"user_totals\\\"\"\n\t}\n" +
+			"\t_, err := q.db.ExecContext(ctx, query)\n",
+	} {
+		if !strings.Contains(queries, want) {
+			t.Fatalf("expected %q in schema_queries.go:\n%s", want, queries)
+		}
+	}
+	for _, unwanted := range []string{"InsertActiveUsers", "InsertUserTotals", "RefreshActiveUsers"} {
+		if strings.Contains(queries, unwanted) {
+			t.Fatalf("did not expect %q for a view:\n%s", unwanted, queries)
+		}
+	}
+	mock, err := os.ReadFile(filepath.Join(dir, "mock_querier.go"))
+	if err != nil {
+		t.Fatalf("read mock_querier.go: %v", err)
+	}
+	if !strings.Contains(string(mock), "RefreshUserTotalsFunc func(ctx context.Context, concurrently bool) error") {
+		t.Fatalf("expected refresh in MockQuerier:\n%s", mock)
+	}
+}
+
+func TestCodeGeneratorSchemaQueriesRefreshParamsStruct(t *testing.T) {
+	dir := t.TempDir()
+	tables := []*Table{
+		{Schema: "public", Name: "user_totals", Kind: RelationMaterializedView, Columns: []TableColumn{{Name: "total", DBType: "int4"}}},
+	}
+	cg := &CodeGenerator{OutputDir: dir, PackageName: "db", SchemaTables: tables, SchemaQueries: true, ParamsStruct: true, EmitMock: true, ArrayAdapter: "none"}
+	if err := cg.Generate(nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	models := &SchemaModelGenerator{Options: SchemaModelOptions{OutputDir: dir, PackageName: "db"}}
+	if _, err := models.Generate(tables); err != nil {
+		t.Fatalf("Generate schema models: %v", err)
+	}
+	typeCheckDir(t, dir)
+	content, err := os.ReadFile(filepath.Join(dir, "schema_queries.go"))
+	if err != nil {
+		t.Fatalf("read schema_queries.go: %v", err)
+	}
+	if !strings.Contains(string(content), "func (q *Queries) RefreshUserTotals(ctx context.Context, concurrently bool) error") {
+		t.Fatalf("expected refresh to keep its flag param:\n%s", content)
+	}
+}
+
+// typeCheckDir type-checks the generated package in dir against the
+// standard library.
+func typeCheckDir(t *testing.T, dir string) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range paths {
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatalf("parse %s: %v", path, err)
+		}
+		files = append(files, file)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("db", fset, files, nil); err != nil {
+		t.Fatalf("generated package does not compile: %v", err)
+	}
+}
+
+func TestTableProceduresInsertDefaults(t *testing.T) {
+	table := &Table{
+		Schema: "public",
+		Name:   "orders",
+		Columns: []TableColumn{
+			{Name: "id", DBType: "int8", PrimaryKey: true, Default: "GENERATED BY DEFAULT AS IDENTITY"},
+			{Name: "status", DBType: "text", Default: "'pending'::text"},
+			{Name: "active", DBType: "bool", Nullable: true, Default: "true"},
+			{Name: "created_at", DBType: "timestamptz", Default: "now()"},
+		},
+		PrimaryKey: []string{"id"},
+	}
+	for _, proc := range tableProcedures([]*Table{table}) {
+		if proc.Name != "InsertOrders" {
+			continue
+		}
+		want := `INSERT INTO "public"."orders" ("status", "active", "created_at") VALUES ($1, $2, $3) RETURNING "id", "status", "active", "created_at"`
+		if proc.query != want {
+			t.Fatalf("insert query = %s, want %s", proc.query, want)
+		}
+		return
+	}
+	t.Fatal("expected an InsertOrders procedure")
+}
@@ -0,0 +1,403 @@
+package sqlproc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	returnsClausePattern = regexp.MustCompile(`(?is)^\s*returns\s+`)
+	returnsTablePattern  = regexp.MustCompile(`(?is)^table\s*\(`)
+	returnsTypePattern   = regexp.MustCompile(`(?is)^(setof\s+)?(.+?)\s+(as|language|immutable|stable|volatile|strict|security|parallel|cost|rows|support|set|window|leakproof|called|not|external|transform|begin|return)\b`)
+	multiwordTypePattern = regexp.MustCompile(`(?i)^(double\s+precision|character\s+varying|bit\s+varying|(timestamp|time)(\s*\(\d+\))?\s+with(out)?\s+time\s+zone|interval\s+\w+)`)
+)
+
+// routineSignature is the header of a CREATE FUNCTION or CREATE PROCEDURE statement.
+type routineSignature struct {
+	Name      string
+	Procedure bool
+	Args      []Param
+	SetOf     bool
+	// Table holds the columns of RETURNS TABLE(...).
+	Table []Column
+	// Type is the RETURNS type when it is not a TABLE, e.g. "void" or "integer".
+	Type string
+}
+
+// parseSignature reads the routine name, arguments and RETURNS clause from
+// a CREATE FUNCTION or CREATE PROCEDURE statement. It returns nil when no header is found.
+func (p *Parser) parseSignature(sqlText string) *routineSignature {
+	if p.funcPattern == nil {
+		return nil
+	}
+	loc := p.funcPattern.FindStringSubmatchIndex(sqlText)
+	if loc == nil {
+		return nil
+	}
+	sig := &routineSignature{
+		Name:      unquoteIdent(strings.TrimSuffix(sqlText[loc[6]:loc[7]], "(")),
+		Procedure: strings.EqualFold(sqlText[loc[4]:loc[5]], "procedure"),
+	}
+
+	rest := strings.TrimLeft(sqlText[loc[7]:], " \t\r\n")
+	if !strings.HasPrefix(rest, "(") {
+		return nil
+	}
+	end := matchingParen(rest)
+	if end < 0 {
+		return nil
+	}
+	for _, arg := range splitTopLevel(rest[1:end]) {
+		if param, ok := parseSignatureArg(arg); ok {
+			sig.Args = append(sig.Args, param)
+		}
+	}
+
+	rest = rest[end+1:]
+	clause := returnsClausePattern.FindStringIndex(rest)
+	if clause == nil {
+		return sig
+	}
+	rest = rest[clause[1]:]
+	if tbl := returnsTablePattern.FindStringIndex(rest); tbl != nil {
+		body := rest[tbl[1]-1:]
+		if end := matchingParen(body); end >= 0 {
+			for _, col := range splitTopLevel(body[1:end]) {
+				if param, ok := parseSignatureArg(col); ok && param.Name != "" {
+					sig.Table = append(sig.Table, Column{Name: param.Name, DBType: param.DBType})
+				}
+			}
+		}
+		return sig
+	}
+	if m := returnsTypePattern.FindStringSubmatch(rest); m != nil {
+		sig.SetOf = m[1] != ""
+		sig.Type = normalizeType(m[2])
+		return sig
+	}
+	typ := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), ";"))
+	if lower := strings.ToLower(typ); strings.HasPrefix(lower, "setof ") {
+		sig.SetOf = true
+		typ = typ[len("setof "):]
+	}
+	sig.Type = normalizeType(typ)
+	return sig
+}
+
+// params returns the signature arguments with conventional p_ or _
+// prefixes removed so they read naturally as Go identifiers.
+func (s *routineSignature) params() []Param {
+	params := make([]Param, 0, len(s.Args))
+	for i, arg := range s.Args {
+		name := arg.Name
+		for _, prefix := range []string{"p_", "_"} {
+			if len(name) > len(prefix) && strings.HasPrefix(strings.ToLower(name), prefix) {
+				name = name[len(prefix):]
+				break
+			}
+		}
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i+1)
+		}
+		arg.Name = name
+		params = append(params, arg)
+	}
+	return params
+}
+
+// returns derives the result columns. OUT and INOUT columns are named like
+// their params, without p_ or _ prefixes. The second result is false when the
+// columns cannot be known from the signature alone (e.g. RETURNS SETOF users).
+func (s *routineSignature) returns() ([]Column, bool) {
+	if len(s.Table) > 0 {
+		return s.Table, true
+	}
+	var outs []Column
+	for _, arg := range s.params() {
+		if arg.Mode.IsOutput() {
+			outs = append(outs, Column{Name: arg.Name, DBType: arg.DBType})
+		}
+	}
+	if len(outs) > 0 {
+		return outs, true
+	}
+	if s.Procedure {
+		return []Column{}, true
+	}
+	switch s.Type {
+	case "":
+		return nil, false
+	case "void":
+		return []Column{}, true
+	}
+	if sqlTypeToGo(s.Type) == "interface{}" {
+		return nil, false
+	}
+	name := s.Name
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return []Column{{Name: name, DBType: s.Type}}, true
+}
+
+// pseudoTypes never describe result columns, so routines returning them are
+// not resolved against tables or composite types.
+var pseudoTypes = map[string]bool{"trigger": true, "event_trigger": true, "void": true, "record": true}
+
+func isPseudoType(dbType string) bool {
+	_, name := splitQualifiedName(dbType)
+	return pseudoTypes[name]
+}
+
+// mergeParams reconciles -- param comments with the signature. Comments
+// rename input parameters but must agree with the signature on count and type.
+func mergeParams(declared, inferred []Param) ([]Param, error) {
+	if len(declared) == 0 {
+		return inferred, nil
+	}
+	var inputs int
+	for _, param := range inferred {
+		if param.Mode.IsInput() {
+			inputs++
+		}
+	}
+	if len(declared) != inputs {
+		return nil, fmt.Errorf("-- param declares %d parameter(s) but the signature accepts %d", len(declared), inputs)
+	}
+	merged := make([]Param, len(inferred))
+	copy(merged, inferred)
+	next := 0
+	for i, param := range merged {
+		if !param.Mode.IsInput() {
+			continue
+		}
+		decl := declared[next]
+		next++
+		if canonicalType(decl.DBType) != canonicalType(param.DBType) {
+			return nil, fmt.Errorf("-- param %s has type %q but the signature declares %q", decl.Name, decl.DBType, param.DBType)
+		}
+		merged[i].Name = decl.Name
+		merged[i].DBType = decl.DBType
+		merged[i].Nullable = decl.Nullable
+	}
+	return merged, nil
+}
+
+// mergeReturns reconciles -- returns comments with the signature result columns.
+func mergeReturns(declared, inferred []Column, known bool) ([]Column, error) {
+	if !known {
+		return declared, nil
+	}
+	if len(declared) == 0 {
+		return inferred, nil
+	}
+	if len(declared) != len(inferred) {
+		return nil, fmt.Errorf("-- returns declares %d column(s) but the signature returns %d", len(declared), len(inferred))
+	}
+	for i, col := range declared {
+		if canonicalType(col.DBType) != canonicalType(inferred[i].DBType) {
+			return nil, fmt.Errorf("-- returns column %s has type %q but the signature declares %q", col.Name, col.DBType, inferred[i].DBType)
+		}
+	}
+	return declared, nil
+}
+
+func parseSignatureArg(arg string) (Param, bool) {
+	arg = strings.TrimSpace(stripArgDefault(arg))
+	fields := topLevelFields(arg)
+	if len(fields) == 0 {
+		return Param{}, false
+	}
+	param := Param{Mode: ParamIn}
+	if len(fields) > 1 {
+		switch mode := ParamMode(strings.ToUpper(fields[0])); mode {
+		case ParamIn, ParamOut, ParamInOut, ParamVariadic:
+			param.Mode = mode
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 1 && !strings.Contains(fields[0], "(") && !multiwordTypePattern.MatchString(strings.Join(fields, " ")) {
+		param.Name = unquoteIdent(fields[0])
+		fields = fields[1:]
+	}
+	param.DBType = normalizeType(strings.Join(fields, " "))
+	return param, true
+}
+
+// stripArgDefault removes a trailing DEFAULT expression or "= expr".
+func stripArgDefault(arg string) string {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth > 0:
+		case c == '=':
+			return arg[:i]
+		case (c == 'd' || c == 'D') && i > 0 && isSpace(arg[i-1]):
+			if len(arg) > i+7 && strings.EqualFold(arg[i:i+7], "default") && isSpace(arg[i+7]) {
+				return arg[:i]
+			}
+		}
+	}
+	return arg
+}
+
+// topLevelFields splits s on whitespace that is not nested in parentheses or
+// quotes, so "numeric(12, 2)" stays one field. A parenthesised modifier
+// separated by a space, as in "numeric (12, 2)", is joined to the field
+// before it.
+func topLevelFields(s string) []string {
+	var fields []string
+	depth, start := 0, -1
+	var quote byte
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		field := s[start:end]
+		if strings.HasPrefix(field, "(") && len(fields) > 0 {
+			fields[len(fields)-1] += field
+		} else {
+			fields = append(fields, field)
+		}
+		start = -1
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case isSpace(c) && depth == 0:
+			flush(i)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(len(s))
+	return fields
+}
+
+// splitTopLevel splits s on commas that are not nested in parentheses or quotes.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// matchingParen returns the index of the parenthesis closing s[0], or -1.
+func matchingParen(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var typeAliases = map[string]string{
+	"int":         "integer",
+	"int4":        "integer",
+	"int8":        "bigint",
+	"int2":        "smallint",
+	"bool":        "boolean",
+	"float8":      "double precision",
+	"float4":      "real",
+	"varchar":     "character varying",
+	"char":        "character",
+	"bpchar":      "character",
+	"decimal":     "numeric",
+	"timestamptz": "timestamp with time zone",
+	"timestamp":   "timestamp without time zone",
+	"timetz":      "time with time zone",
+	"time":        "time without time zone",
+}
+
+var typmodPattern = regexp.MustCompile(`\s*\([^)]*\)`)
+
+// canonicalType reduces a PostgreSQL type name to a comparable form,
+// folding aliases (int4, integer) and dropping type modifiers.
+func canonicalType(dbType string) string {
+	if elem, ok := arrayElemType(dbType); ok {
+		return canonicalType(elem) + "[]"
+	}
+	t := normalizeType(dbType)
+	t = strings.TrimPrefix(t, "pg_catalog.")
+	t = typmodPattern.ReplaceAllString(t, "")
+	t = strings.Join(strings.Fields(t), " ")
+	if alias, ok := typeAliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+func unquoteIdent(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -4,13 +4,40 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
-// Migrator executes stored procedure definitions against a database.
+// Migrator executes stored procedure definitions against a database and
+// records each applied definition in sqlproc_procedures.
 type Migrator struct {
-	db *sql.DB
+	db sqlConn
+	// tx is set by MigrateTx; all statements then run inside it.
+	tx *sql.Tx
+
+	// Force re-applies procedures whose definition is unchanged.
+	Force bool
+	// Prune drops routines that are tracked but no longer in the source tree.
+	// Only enable it when Migrate receives every procedure.
+	Prune bool
+	// SingleTransaction applies every changed procedure and prune in one
+	// transaction, so any failure leaves all routines as they were.
+	SingleTransaction bool
+	// DropCascade lets Migrate drop a routine it must replace even when
+	// other objects (views, triggers, defaults) depend on it, dropping those
+	// objects too. By default such a migration fails and lists the dependents.
+	DropCascade bool
+	// DropUntrackedOverloads drops other overloads of an applied routine that
+	// sqlproc has not recorded, e.g. ones created by hand, by a schema
+	// migration or before tracking existed. By default they are only
+	// reported, since other code may still call them.
+	DropUntrackedOverloads bool
+	// Logger reports changed and orphaned procedures. Defaults to the
+	// standard logger.
+	Logger Logger
 }
 
 // NewMigrator constructs a migrator.
@@ -18,16 +45,136 @@ func NewMigrator(db *sql.DB) *Migrator {
 	return &Migrator{db: db}
 }
 
-// Migrate executes each procedure on the database.
+// MigrateTx is like Migrate but runs everything inside tx, which the caller
+// commits or rolls back.
+func (m *Migrator) MigrateTx(ctx context.Context, tx *sql.Tx, procedures []*Procedure) error {
+	scoped := *m
+	scoped.tx = tx
+	return scoped.Migrate(ctx, procedures)
+}
+
+// conn returns the transaction set by MigrateTx or the database handle.
+func (m *Migrator) conn() sqlQueryer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
+// Migrate executes each procedure whose definition changed since it was last
+// applied, in dependency order (see SortProcedures), then reports (and with
+// Prune, drops) tracked procedures missing from procedures.
 func (m *Migrator) Migrate(ctx context.Context, procedures []*Procedure) error {
+	procedures, err := SortProcedures(procedures)
+	if err != nil {
+		return err
+	}
+	if m.SingleTransaction && m.tx == nil {
+		return withTx(ctx, m.db, nil, func(tx *sql.Tx) error {
+			return m.MigrateTx(ctx, tx, procedures)
+		})
+	}
+	logger := m.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	if len(procedures) == 0 {
+		// Nothing to apply, but routines tracked by an earlier run must
+		// still be reported or pruned.
+		var exists bool
+		if err := m.conn().QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, proceduresTable).Scan(&exists); err != nil || !exists {
+			return err
+		}
+	} else if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	recorded, err := m.recordedProcedures(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(procedures))
+	signatures := make(map[string]bool, len(procedures))
+	keep := make([]string, 0, len(procedures))
+	for _, proc := range procedures {
+		current[proc.Name] = true
+		if !signatures[proc.Signature()] {
+			keep = append(keep, proc.Signature())
+		}
+		signatures[proc.Signature()] = true
+	}
 	for _, proc := range procedures {
-		if err := m.exec(ctx, proc.SQL); err != nil {
-			return fmt.Errorf("migrate %s: %w", proc.File, err)
+		record, ok := recorded[proc.Name]
+		if ok && record.Hash == proc.DefinitionHash() && !m.Force {
+			continue
+		}
+		var stale string
+		if ok && record.Signature != proc.Signature() && !signatures[record.Signature] {
+			stale = record.Signature
 		}
+		if err := m.apply(ctx, proc, stale, keep, logger); err != nil {
+			return fmt.Errorf("migrate %w", locateError(err, proc.File, proc.SQL, proc.StartLine))
+		}
+		logger.Printf("applied %s (%s)", proc.SQLName, proc.File)
+	}
+
+	names := make([]string, 0, len(recorded))
+	for name := range recorded {
+		if !current[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		record := recorded[name]
+		if !m.Prune {
+			logger.Printf("warning: %s (%s) is tracked but no longer in the source tree; enable pruning to drop it", record.Signature, record.File)
+			continue
+		}
+		if err := m.prune(ctx, record, signatures[record.Signature]); err != nil {
+			return fmt.Errorf("prune %s: %w", record.Signature, err)
+		}
+		logger.Printf("pruned %s (%s)", record.Signature, record.File)
 	}
 	return nil
 }
 
+// apply creates or replaces proc, dropping the routine first when its return
+// type or OUT parameters changed. It then drops stale (the signature
+// previously recorded for proc) when it is a different routine, and reports
+// or drops the remaining overloads of proc that are not in keep. Everything
+// runs in one transaction.
+func (m *Migrator) apply(ctx context.Context, proc *Procedure, stale string, keep []string, logger Logger) error {
+	return withTx(ctx, m.db, m.tx, func(tx *sql.Tx) error {
+		if err := m.replaceRoutine(ctx, tx, proc, logger); err != nil {
+			return err
+		}
+		if stale != "" {
+			if err := m.dropStaleRoutine(ctx, tx, stale, proc.Signature(), logger); err != nil {
+				return err
+			}
+		}
+		if err := m.untrackedOverloads(ctx, tx, proc, keep, logger); err != nil {
+			return err
+		}
+		return m.recordProcedure(ctx, tx, proc)
+	})
+}
+
+// prune drops an orphaned routine and forgets it. A routine whose signature
+// now belongs to a current procedure (for example after a Go rename) is kept.
+func (m *Migrator) prune(ctx context.Context, record procedureRecord, inUse bool) error {
+	return withTx(ctx, m.db, m.tx, func(tx *sql.Tx) error {
+		if !inUse {
+			if err := m.dropRoutine(ctx, tx, record.Signature); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM `+proceduresTable+` WHERE name = $1`, record.Name)
+		return err
+	})
+}
+
 // MigrateFiles parses provided SQL files then migrates them.
 func (m *Migrator) MigrateFiles(ctx context.Context, files []string) error {
 	parser := NewParser()
@@ -38,17 +185,37 @@ func (m *Migrator) MigrateFiles(ctx context.Context, files []string) error {
 	return m.Migrate(ctx, procs)
 }
 
-func (m *Migrator) exec(ctx context.Context, sqlText string) error {
-	if sqlText == "" {
-		return nil
-	}
-	_, err := m.db.ExecContext(ctx, sqlText)
-	return err
-}
-
 // GeneratorOptions configure code generation.
 type GeneratorOptions struct {
 	PackageName string
+	// NullStyle selects how nullable params and return columns are typed:
+	// pointers (default), database/sql Null* types, or generic sql.Null[T].
+	NullStyle NullStyle
+	// TypeOverrides replace the Go types chosen for database types or for
+	// specific "proc.param" columns. Required imports are added automatically.
+	TypeOverrides []TypeOverride
+	// ArrayAdapter is the qualified function used to bind and scan PostgreSQL
+	// arrays, e.g. "github.com/lib/pq.Array" (the default). Use "none" for
+	// drivers that handle slices natively.
+	ArrayAdapter string
+	// Enums are PostgreSQL enum types to emit as Go string types. Run loads
+	// them from the database automatically when a connection is available.
+	Enums []*Enum
+	// SchemaTables are tables whose schema models are generated into the
+	// same package. Procedures returning SETOF one of these tables reuse the
+	// model struct as their row type. Run sets this automatically.
+	SchemaTables []*Table
+	// ParamsStruct makes every generated method take a <Name>Params struct
+	// instead of positional arguments. Procedures can opt in individually
+	// with a "-- option: params_struct" comment.
+	ParamsStruct bool
+	// EmitMock also writes mock_querier.go, a function-field based Querier
+	// for unit tests.
+	EmitMock bool
+	// SchemaQueries also writes schema_queries.go with Get<Table>ByPK,
+	// List<Table>, Insert<Table>, Update<Table> and Delete<Table> methods for
+	// SchemaTables. Only tables with a primary key get Get, Update and Delete.
+	SchemaQueries bool
 }
 
 // Generator writes strongly typed Go helpers for stored procedures.
@@ -76,7 +243,7 @@ func (g *Generator) GenerateFiles(files []string, outputDir string) error {
 
 // Generate writes code for provided procedures.
 func (g *Generator) Generate(procs []*Procedure, outputDir string) error {
-	if len(procs) == 0 {
+	if len(procs) == 0 && !(g.opts.SchemaQueries && len(g.opts.SchemaTables) > 0) {
 		return fmt.Errorf("no procedures to generate")
 	}
 
@@ -85,8 +252,16 @@ func (g *Generator) Generate(procs []*Procedure, outputDir string) error {
 	}
 
 	cg := &CodeGenerator{
-		OutputDir:   outputDir,
-		PackageName: g.opts.PackageName,
+		OutputDir:     outputDir,
+		PackageName:   g.opts.PackageName,
+		NullStyle:     g.opts.NullStyle,
+		TypeOverrides: g.opts.TypeOverrides,
+		ArrayAdapter:  g.opts.ArrayAdapter,
+		Enums:         g.opts.Enums,
+		SchemaTables:  g.opts.SchemaTables,
+		ParamsStruct:  g.opts.ParamsStruct,
+		EmitMock:      g.opts.EmitMock,
+		SchemaQueries: g.opts.SchemaQueries,
 	}
 	return cg.Generate(procs)
 }
@@ -105,17 +280,27 @@ func (g *Generator) GenerateToTemp(files []string) (string, error) {
 
 // ResolveFiles expands mixed directories/file inputs into a list of SQL files.
 func ResolveFiles(inputs []string) ([]string, error) {
+	return resolveFiles(nil, inputs)
+}
+
+// ResolveFilesFS is like ResolveFiles but resolves slash-separated inputs
+// within fsys, such as an embed.FS.
+func ResolveFilesFS(fsys fs.FS, inputs []string) ([]string, error) {
+	return resolveFiles(fsys, inputs)
+}
+
+func resolveFiles(fsys fs.FS, inputs []string) ([]string, error) {
 	var files []string
 	for _, in := range inputs {
 		if in == "" {
 			continue
 		}
-		info, err := os.Stat(in)
+		info, err := statFile(fsys, in)
 		if err != nil {
 			return nil, fmt.Errorf("stat %s: %w", in, err)
 		}
 		if info.IsDir() {
-			dirFiles, err := CollectSQLFiles(in)
+			dirFiles, err := collectSQLFiles(fsys, in)
 			if err != nil {
 				return nil, err
 			}
@@ -132,3 +317,19 @@ func ResolveFiles(inputs []string) ([]string, error) {
 	}
 	return files, nil
 }
+
+// statFile and readFile use fsys when it is set and the OS filesystem
+// otherwise, so every loader has one implementation for both.
+func statFile(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
@@ -0,0 +1,185 @@
+package sqlproc
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dollarTagPattern = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// sqlByteClass classifies a byte of SQL text.
+type sqlByteClass int
+
+const (
+	sqlCode sqlByteClass = iota
+	sqlComment
+	sqlQuoted
+)
+
+// sqlScanState tracks lexical context across calls to walkSQL so that
+// text can be fed line by line.
+type sqlScanState struct {
+	dollarTag    string
+	quote        byte
+	commentDepth int
+	lineComment  bool
+}
+
+// topLevel reports whether the scanner is outside strings, dollar quotes
+// and block comments.
+func (s *sqlScanState) topLevel() bool {
+	return s.dollarTag == "" && s.quote == 0 && s.commentDepth == 0
+}
+
+// walkSQL classifies each byte of text, honouring '...' and "..." quoting,
+// $$/$tag$ dollar quoting, -- line comments and nested /* */ comments.
+func walkSQL(text string, state *sqlScanState, fn func(i int, class sqlByteClass)) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case state.lineComment:
+			if c == '\n' {
+				state.lineComment = false
+				fn(i, sqlCode)
+				continue
+			}
+			fn(i, sqlComment)
+		case state.dollarTag != "":
+			if strings.HasPrefix(text[i:], state.dollarTag) {
+				for j := 0; j < len(state.dollarTag); j++ {
+					fn(i+j, sqlQuoted)
+				}
+				i += len(state.dollarTag) - 1
+				state.dollarTag = ""
+				continue
+			}
+			fn(i, sqlQuoted)
+		case state.commentDepth > 0:
+			switch {
+			case strings.HasPrefix(text[i:], "/*"):
+				state.commentDepth++
+				fn(i, sqlComment)
+				i++
+			case strings.HasPrefix(text[i:], "*/"):
+				state.commentDepth--
+				fn(i, sqlComment)
+				i++
+			}
+			fn(i, sqlComment)
+		case state.quote != 0:
+			if c == state.quote {
+				state.quote = 0
+			}
+			fn(i, sqlQuoted)
+		case strings.HasPrefix(text[i:], "--"):
+			state.lineComment = true
+			fn(i, sqlComment)
+		case strings.HasPrefix(text[i:], "/*"):
+			state.commentDepth++
+			fn(i, sqlComment)
+			i++
+			fn(i, sqlComment)
+		case c == '\'' || c == '"':
+			state.quote = c
+			fn(i, sqlQuoted)
+		case c == '$' && (i == 0 || !isIdentByte(text[i-1])):
+			tag := dollarTagPattern.FindString(text[i:])
+			if tag == "" {
+				fn(i, sqlCode)
+				continue
+			}
+			state.dollarTag = tag
+			for j := 0; j < len(tag); j++ {
+				fn(i+j, sqlQuoted)
+			}
+			i += len(tag) - 1
+		default:
+			fn(i, sqlCode)
+		}
+	}
+}
+
+// sqlLine is a source line annotated with its lexical context.
+type sqlLine struct {
+	Text   string
+	Number int
+	// TopLevel is true when the line starts outside any string, dollar quote
+	// or block comment, so comment directives on it are meaningful.
+	TopLevel bool
+}
+
+// scanSQLLines splits content into numbered lines, tracking whether each
+// line starts inside a dollar-quoted body or other quoted context.
+func scanSQLLines(content string) []sqlLine {
+	raw := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	lines := make([]sqlLine, 0, len(raw))
+	var state sqlScanState
+	for i, text := range raw {
+		lines = append(lines, sqlLine{Text: text, Number: i + 1, TopLevel: state.topLevel()})
+		walkSQL(text+"\n", &state, func(int, sqlByteClass) {})
+	}
+	return lines
+}
+
+// stripSQLComments blanks out comments while preserving offsets, newlines
+// and quoted text, so header regexes do not match commented-out SQL.
+func stripSQLComments(sqlText string) string {
+	buf := []byte(sqlText)
+	var state sqlScanState
+	walkSQL(sqlText, &state, func(i int, class sqlByteClass) {
+		if class == sqlComment && buf[i] != '\n' {
+			buf[i] = ' '
+		}
+	})
+	return string(buf)
+}
+
+// splitSQLStatements splits text on top-level semicolons. Empty statements
+// and comment-only fragments are dropped.
+func splitSQLStatements(sqlText string) []string {
+	var statements []string
+	var state sqlScanState
+	start := 0
+	hasCode := false
+	flush := func(end int) {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(sqlText[start:end]))
+		}
+		start = end + 1
+		hasCode = false
+	}
+	walkSQL(sqlText, &state, func(i int, class sqlByteClass) {
+		switch {
+		case class == sqlCode && sqlText[i] == ';':
+			flush(i)
+		case class != sqlComment && !isSpace(sqlText[i]):
+			hasCode = true
+		}
+	})
+	flush(len(sqlText))
+	return statements
+}
+
+// dollarQuotedBody returns the contents of the first dollar-quoted string
+// in sqlText, which for a CREATE FUNCTION/PROCEDURE statement is the routine
+// body PostgreSQL stores in pg_proc.prosrc.
+func dollarQuotedBody(sqlText string) (string, bool) {
+	var state sqlScanState
+	start, end := -1, -1
+	walkSQL(sqlText, &state, func(i int, class sqlByteClass) {
+		if start < 0 && state.dollarTag != "" {
+			start = i + len(state.dollarTag)
+			if idx := strings.Index(sqlText[start:], state.dollarTag); idx >= 0 {
+				end = start + idx
+			}
+		}
+	})
+	if start < 0 || end < 0 {
+		return "", false
+	}
+	return sqlText[start:end], true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
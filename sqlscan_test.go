@@ -0,0 +1,42 @@
+package sqlproc
+
+import "testing"
+
+func TestSplitSQLStatements(t *testing.T) {
+	sql := `CREATE TABLE a(id INT); -- trailing; comment
+/* block; comment */
+CREATE FUNCTION f() RETURNS text AS $fn$ SELECT 'x;y'; $fn$ LANGUAGE sql;
+INSERT INTO a VALUES (1)`
+	got := splitSQLStatements(sql)
+	want := []string{
+		"CREATE TABLE a(id INT)",
+		"-- trailing; comment\n/* block; comment */\nCREATE FUNCTION f() RETURNS text AS $fn$ SELECT 'x;y'; $fn$ LANGUAGE sql",
+		"INSERT INTO a VALUES (1)",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statements, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("statement %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStripSQLComments(t *testing.T) {
+	got := stripSQLComments("-- create function x\nCREATE /* or replace */ FUNCTION y() AS $$ -- kept $$")
+	want := "                    \nCREATE                  FUNCTION y() AS $$ -- kept $$"
+	if got != want {
+		t.Fatalf("stripSQLComments = %q, want %q", got, want)
+	}
+}
+
+func TestDollarQuotedBody(t *testing.T) {
+	got, ok := dollarQuotedBody("-- $$ in a comment\nCREATE FUNCTION f(p text DEFAULT 'a$$b') RETURNS text AS $fn$\n  SELECT $$x$$;\n$fn$ LANGUAGE sql;")
+	if !ok || got != "\n  SELECT $$x$$;\n" {
+		t.Fatalf("dollarQuotedBody = %q, %v", got, ok)
+	}
+	if _, ok := dollarQuotedBody("CREATE FUNCTION f() RETURNS int AS 'SELECT 1' LANGUAGE sql;"); ok {
+		t.Fatal("expected no dollar-quoted body")
+	}
+}
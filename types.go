@@ -0,0 +1,345 @@
+package sqlproc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NullStyle selects how nullable values are represented in generated Go code.
+type NullStyle string
+
+const (
+	// NullStylePointer maps nullable values to pointers (*string). This is the default.
+	NullStylePointer NullStyle = "pointer"
+	// NullStyleSQL maps nullable values to database/sql types such as sql.NullString,
+	// falling back to sql.Null[T] when no dedicated type exists.
+	NullStyleSQL NullStyle = "sql"
+	// NullStyleGeneric maps nullable values to sql.Null[T].
+	NullStyleGeneric NullStyle = "generic"
+)
+
+func (s NullStyle) validate() error {
+	switch s {
+	case "", NullStylePointer, NullStyleSQL, NullStyleGeneric:
+		return nil
+	default:
+		return fmt.Errorf("unknown null style %q (expected pointer, sql or generic)", s)
+	}
+}
+
+var sqlNullTypes = map[string]string{
+	"string":    "sql.NullString",
+	"int64":     "sql.NullInt64",
+	"int32":     "sql.NullInt32",
+	"int16":     "sql.NullInt16",
+	"byte":      "sql.NullByte",
+	"bool":      "sql.NullBool",
+	"float64":   "sql.NullFloat64",
+	"time.Time": "sql.NullTime",
+}
+
+// nullableGoType wraps base according to style when nullable is set.
+// Slices and interface{} already represent NULL as nil and are left alone.
+func nullableGoType(base string, nullable bool, style NullStyle) string {
+	if !nullable || strings.HasPrefix(base, "[]") || base == "interface{}" {
+		return base
+	}
+	switch style {
+	case NullStyleSQL:
+		if named, ok := sqlNullTypes[base]; ok {
+			return named
+		}
+		return "sql.Null[" + base + "]"
+	case NullStyleGeneric:
+		return "sql.Null[" + base + "]"
+	default:
+		return "*" + base
+	}
+}
+
+// TypeOverride replaces the Go type generated for a database type or for one
+// specific procedure param/column or table column.
+type TypeOverride struct {
+	// DBType matches a database type such as "numeric" or "uuid". Aliases and
+	// type modifiers are folded, so "numeric" also matches "numeric(12, 2)",
+	// and the public schema is optional, so "public.mood" matches "mood".
+	DBType string
+	// Column matches a single "proc.param", "proc.column", "table.column" or
+	// "schema.table.column". Procedures may be named by their Go or SQL name.
+	Column string
+	// GoType is the Go type, qualified by import path when it lives in another
+	// package, e.g. "github.com/shopspring/decimal.Decimal" or "string".
+	GoType string
+}
+
+// ParseTypeOverride parses "match=goType" specs such as
+// "uuid=github.com/google/uuid.UUID" or "users.id=int64". A match prefixed
+// with "type:" or "column:" selects a database type or a column explicitly,
+// as in "type:public.mood=string"; otherwise a match containing a dot is
+// treated as a column and any other match as a database type.
+func ParseTypeOverride(spec string) (TypeOverride, error) {
+	match, goType, ok := strings.Cut(spec, "=")
+	match, goType = strings.TrimSpace(match), strings.TrimSpace(goType)
+	kind, name, explicit := strings.Cut(match, ":")
+	if explicit {
+		match = strings.TrimSpace(name)
+	}
+	if !ok || match == "" || goType == "" {
+		return TypeOverride{}, fmt.Errorf("invalid type override %q (expected match=goType)", spec)
+	}
+	switch {
+	case explicit && strings.TrimSpace(kind) == "type":
+		return TypeOverride{DBType: match, GoType: goType}, nil
+	case explicit && strings.TrimSpace(kind) == "column":
+		return TypeOverride{Column: match, GoType: goType}, nil
+	case explicit:
+		return TypeOverride{}, fmt.Errorf("invalid type override %q (match prefix must be type: or column:)", spec)
+	case strings.Contains(match, "."):
+		return TypeOverride{Column: match, GoType: goType}, nil
+	}
+	return TypeOverride{DBType: match, GoType: goType}, nil
+}
+
+// overrideTypeKey is the byType key of dbType. Types in the public schema
+// match with or without their schema, so "public.mood" overrides "mood".
+func overrideTypeKey(dbType string) string {
+	return strings.TrimPrefix(canonicalType(dbType), "public.")
+}
+
+// typeOverrides is the compiled form of []TypeOverride.
+type typeOverrides struct {
+	byType   map[string]string
+	byColumn map[string]string
+	packages map[string]string
+}
+
+func compileTypeOverrides(overrides []TypeOverride) (*typeOverrides, error) {
+	compiled := &typeOverrides{
+		byType:   make(map[string]string),
+		byColumn: make(map[string]string),
+		packages: make(map[string]string),
+	}
+	for _, o := range overrides {
+		expr, qualifier, path, err := parseGoType(o.GoType)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			if builtin, ok := builtinPackages[qualifier]; ok && builtin != path {
+				return nil, fmt.Errorf("type override %s imports %s as %q, which generated code uses for %s", o.GoType, path, qualifier, builtin)
+			}
+			if existing, ok := compiled.packages[qualifier]; ok && existing != path {
+				return nil, fmt.Errorf("type overrides import %s and %s under the same name %q", existing, path, qualifier)
+			}
+			compiled.packages[qualifier] = path
+		}
+		switch {
+		case o.Column != "":
+			compiled.byColumn[strings.ToLower(o.Column)] = expr
+		case o.DBType != "":
+			compiled.byType[overrideTypeKey(o.DBType)] = expr
+		default:
+			return nil, fmt.Errorf("type override for %q must set DBType or Column", o.GoType)
+		}
+	}
+	return compiled, nil
+}
+
+var goVersionSuffix = regexp.MustCompile(`^v\d+$`)
+
+// parseGoType splits a qualified type such as "*github.com/google/uuid.UUID"
+// into the Go expression "*uuid.UUID", its package qualifier and import path.
+func parseGoType(spec string) (expr, qualifier, path string, err error) {
+	spec = strings.TrimSpace(spec)
+	var prefix string
+	for strings.HasPrefix(spec, "*") || strings.HasPrefix(spec, "[]") {
+		n := 1
+		if spec[0] == '[' {
+			n = 2
+		}
+		prefix += spec[:n]
+		spec = spec[n:]
+	}
+	if spec == "" {
+		return "", "", "", fmt.Errorf("invalid Go type %q", prefix)
+	}
+	slash := strings.LastIndex(spec, "/")
+	dot := strings.LastIndex(spec[slash+1:], ".")
+	if dot < 0 {
+		if slash >= 0 {
+			return "", "", "", fmt.Errorf("invalid Go type %q (expected import/path.Type)", spec)
+		}
+		return prefix + spec, "", "", nil
+	}
+	dot += slash + 1
+	path, name := spec[:dot], spec[dot+1:]
+	elems := strings.Split(path, "/")
+	qualifier = elems[len(elems)-1]
+	if goVersionSuffix.MatchString(qualifier) && len(elems) > 1 {
+		qualifier = elems[len(elems)-2]
+	}
+	if idx := strings.Index(qualifier, ".v"); idx > 0 {
+		qualifier = qualifier[:idx]
+	}
+	qualifier = strings.ReplaceAll(strings.TrimPrefix(qualifier, "go-"), "-", "_")
+	return prefix + qualifier + "." + name, qualifier, path, nil
+}
+
+var qualifierPattern = regexp.MustCompile(`\b([a-z][A-Za-z0-9_]*)\.[A-Z]`)
+
+// builtinPackages are the packages generated code imports on its own, by
+// qualifier. Type overrides may not import other packages under these names.
+var builtinPackages = map[string]string{
+	"context": "context",
+	"sql":     "database/sql",
+	"time":    "time",
+}
+
+// typeMapper resolves database types to Go types for one generated file and
+// records the imports those types require.
+type typeMapper struct {
+	nullStyle NullStyle
+	overrides *typeOverrides
+	enums     map[string]string
+	packages  map[string]string
+	used      map[string]bool
+}
+
+func newTypeMapper(style NullStyle, overrides *typeOverrides, enums []*Enum) *typeMapper {
+	m := &typeMapper{
+		nullStyle: style,
+		overrides: overrides,
+		enums:     enumTypeNames(enums),
+		packages:  make(map[string]string),
+		used:      make(map[string]bool),
+	}
+	for qualifier, path := range builtinPackages {
+		m.packages[qualifier] = path
+	}
+	if overrides != nil {
+		for qualifier, path := range overrides.packages {
+			m.packages[qualifier] = path
+		}
+	}
+	return m
+}
+
+// goType maps a database type to a Go type, honouring nullability and
+// database type overrides.
+func (m *typeMapper) goType(dbType string, nullable bool) string {
+	return m.columnType(nil, "", dbType, nullable)
+}
+
+// columnType maps a named column or param, checking column overrides under
+// each scope (e.g. the procedure or table name) before type overrides.
+func (m *typeMapper) columnType(scopes []string, name, dbType string, nullable bool) string {
+	base := m.baseType(dbType)
+	if m.overrides != nil {
+		for _, scope := range scopes {
+			if expr, ok := m.overrides.byColumn[strings.ToLower(scope+"."+name)]; ok {
+				base = expr
+				break
+			}
+		}
+	}
+	return m.use(nullableGoType(base, nullable, m.nullStyle))
+}
+
+// baseType maps a database type using type overrides and enums. Arrays without an
+// override of their own become slices of their (possibly overridden) element type.
+func (m *typeMapper) baseType(dbType string) string {
+	if m.overrides != nil {
+		if expr, ok := m.overrides.byType[overrideTypeKey(dbType)]; ok {
+			return expr
+		}
+	}
+	if name, ok := m.enums[normalizeType(dbType)]; ok {
+		return name
+	}
+	if elem, ok := arrayElemType(dbType); ok {
+		return "[]" + m.baseType(elem)
+	}
+	return sqlTypeToGo(dbType)
+}
+
+// procScopes returns the names a column override may use for a procedure.
+func procScopes(p *Procedure) []string {
+	scopes := []string{p.Name}
+	if p.SQLName != "" {
+		scopes = append(scopes, p.SQLName)
+		if idx := strings.LastIndex(p.SQLName, "."); idx >= 0 {
+			scopes = append(scopes, p.SQLName[idx+1:])
+		}
+	}
+	return scopes
+}
+
+// tableScopes returns the names a column override may use for a table.
+func tableScopes(t *Table) []string {
+	return []string{t.Name, t.Schema + "." + t.Name}
+}
+
+// qualify resolves a qualified identifier such as "github.com/lib/pq.Array",
+// records its import and returns the expression to use in generated code.
+func (m *typeMapper) qualify(spec string) (string, error) {
+	expr, qualifier, path, err := parseGoType(spec)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		if existing, ok := m.packages[qualifier]; ok && existing != path {
+			return "", fmt.Errorf("%s imports %s as %q, which generated code uses for %s", spec, path, qualifier, existing)
+		}
+		m.packages[qualifier] = path
+	}
+	return m.use(expr), nil
+}
+
+// use records the packages referenced by a Go type expression.
+func (m *typeMapper) use(goType string) string {
+	for _, match := range qualifierPattern.FindAllStringSubmatch(goType, -1) {
+		if path, ok := m.packages[match[1]]; ok {
+			m.used[importSpec(match[1], path)] = true
+		}
+	}
+	return goType
+}
+
+// imports returns the import specs used so far (see importSpec), sorted.
+func (m *typeMapper) imports() []string {
+	specs := make([]string, 0, len(m.used))
+	for spec := range m.used {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	return specs
+}
+
+// importSpec returns path, prefixed with "qualifier " when the qualifier
+// generated code uses differs from the last element of the import path.
+func importSpec(qualifier, path string) string {
+	if path[strings.LastIndex(path, "/")+1:] == qualifier {
+		return path
+	}
+	return qualifier + " " + path
+}
+
+// splitNullability strips a trailing "?", "null" or "not null" marker from
+// a metadata type, reporting whether the value may be NULL.
+func splitNullability(dbType string) (string, bool) {
+	dbType = strings.TrimSpace(dbType)
+	if strings.HasSuffix(dbType, "?") {
+		return normalizeType(strings.TrimSuffix(dbType, "?")), true
+	}
+	fields := strings.Fields(dbType)
+	n := len(fields)
+	if n >= 2 && strings.EqualFold(fields[n-1], "null") {
+		if n >= 3 && strings.EqualFold(fields[n-2], "not") {
+			return normalizeType(strings.Join(fields[:n-2], " ")), false
+		}
+		return normalizeType(strings.Join(fields[:n-1], " ")), true
+	}
+	return normalizeType(dbType), false
+}
@@ -0,0 +1,150 @@
+package sqlproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGoType(t *testing.T) {
+	cases := []struct {
+		spec, expr, path string
+	}{
+		{"string", "string", ""},
+		{"github.com/shopspring/decimal.Decimal", "decimal.Decimal", "github.com/shopspring/decimal"},
+		{"*github.com/google/uuid.UUID", "*uuid.UUID", "github.com/google/uuid"},
+		{"[]github.com/jackc/pgx/v5/pgtype.Text", "[]pgtype.Text", "github.com/jackc/pgx/v5/pgtype"},
+		{"github.com/jackc/pgx/v5.Rows", "pgx.Rows", "github.com/jackc/pgx/v5"},
+		{"gopkg.in/yaml.v3.Node", "yaml.Node", "gopkg.in/yaml.v3"},
+		{"encoding/json.RawMessage", "json.RawMessage", "encoding/json"},
+	}
+	for _, c := range cases {
+		expr, _, path, err := parseGoType(c.spec)
+		if err != nil {
+			t.Fatalf("parseGoType(%q) error: %v", c.spec, err)
+		}
+		if expr != c.expr || path != c.path {
+			t.Fatalf("parseGoType(%q) = %q, %q; want %q, %q", c.spec, expr, path, c.expr, c.path)
+		}
+	}
+}
+
+func TestTypeMapperOverrides(t *testing.T) {
+	overrides, err := compileTypeOverrides([]TypeOverride{
+		{DBType: "numeric", GoType: "github.com/shopspring/decimal.Decimal"},
+		{DBType: "uuid", GoType: "github.com/google/uuid.UUID"},
+		{Column: "users.legacy_id", GoType: "string"},
+	})
+	if err != nil {
+		t.Fatalf("compileTypeOverrides error: %v", err)
+	}
+	types := newTypeMapper(NullStylePointer, overrides, nil)
+	table := &Table{Schema: "public", Name: "users"}
+
+	cases := []struct {
+		name, dbType string
+		nullable     bool
+		want         string
+	}{
+		{"balance", "numeric(12, 2)", false, "decimal.Decimal"},
+		{"id", "uuid", true, "*uuid.UUID"},
+		{"legacy_id", "uuid", false, "string"},
+		{"created_at", "timestamptz", false, "time.Time"},
+	}
+	for _, c := range cases {
+		if got := types.columnType(tableScopes(table), c.name, c.dbType, c.nullable); got != c.want {
+			t.Fatalf("columnType(%s %s) = %s, want %s", c.name, c.dbType, got, c.want)
+		}
+	}
+	imports := types.imports()
+	want := []string{"github.com/google/uuid", "github.com/shopspring/decimal", "time"}
+	if len(imports) != len(want) {
+		t.Fatalf("imports = %v, want %v", imports, want)
+	}
+	for i := range want {
+		if imports[i] != want[i] {
+			t.Fatalf("imports = %v, want %v", imports, want)
+		}
+	}
+}
+
+func TestParseTypeOverride(t *testing.T) {
+	o, err := ParseTypeOverride("get_user.id = int64")
+	if err != nil || o.Column != "get_user.id" || o.GoType != "int64" {
+		t.Fatalf("unexpected override %+v (err %v)", o, err)
+	}
+	o, err = ParseTypeOverride("uuid=github.com/google/uuid.UUID")
+	if err != nil || o.DBType != "uuid" {
+		t.Fatalf("unexpected override %+v (err %v)", o, err)
+	}
+	o, err = ParseTypeOverride("type:public.mood=github.com/acme/mood.Mood")
+	if err != nil || o.DBType != "public.mood" || o.Column != "" {
+		t.Fatalf("unexpected override %+v (err %v)", o, err)
+	}
+	o, err = ParseTypeOverride("column: users.id=int64")
+	if err != nil || o.Column != "users.id" || o.DBType != "" {
+		t.Fatalf("unexpected override %+v (err %v)", o, err)
+	}
+	if _, err := ParseTypeOverride("uuid"); err == nil {
+		t.Fatal("expected error for spec without =")
+	}
+	if _, err := ParseTypeOverride("table:users.id=int64"); err == nil {
+		t.Fatal("expected error for unknown match prefix")
+	}
+}
+
+func TestTypeOverrideSchemaQualifiedType(t *testing.T) {
+	o, err := ParseTypeOverride("type:public.mood=int64")
+	if err != nil {
+		t.Fatalf("ParseTypeOverride error: %v", err)
+	}
+	overrides, err := compileTypeOverrides([]TypeOverride{o})
+	if err != nil {
+		t.Fatalf("compileTypeOverrides error: %v", err)
+	}
+	types := newTypeMapper(NullStylePointer, overrides, nil)
+	for _, dbType := range []string{"mood", "public.mood"} {
+		if got := types.goType(dbType, false); got != "int64" {
+			t.Fatalf("goType(%q) = %q, want int64", dbType, got)
+		}
+	}
+	if got := types.goType("audit.mood", false); got == "int64" {
+		t.Fatal("override for public.mood must not apply to audit.mood")
+	}
+}
+
+func TestTypeOverridesRejectBuiltinQualifiers(t *testing.T) {
+	for _, goType := range []string{"github.com/acme/time.Stamp", "example.com/sql.Money"} {
+		_, err := compileTypeOverrides([]TypeOverride{{DBType: "money", GoType: goType}})
+		if err == nil || !strings.Contains(err.Error(), "which generated code uses for") {
+			t.Fatalf("%s: expected builtin qualifier error, got %v", goType, err)
+		}
+	}
+	if _, err := compileTypeOverrides([]TypeOverride{{DBType: "interval", GoType: "time.Duration"}}); err != nil {
+		t.Fatalf("time.Duration override: %v", err)
+	}
+
+	types := newTypeMapper(NullStylePointer, nil, nil)
+	if _, err := types.qualify("github.com/acme/sql.Array"); err == nil {
+		t.Fatal("expected qualify to reject a package named sql")
+	}
+}
+
+func TestTypeOverrideAliasedImports(t *testing.T) {
+	overrides, err := compileTypeOverrides([]TypeOverride{
+		{DBType: "numeric", GoType: "github.com/acme/go-decimal.Decimal"},
+		{DBType: "uuid", GoType: "github.com/google/uuid.UUID"},
+	})
+	if err != nil {
+		t.Fatalf("compileTypeOverrides error: %v", err)
+	}
+	types := newTypeMapper(NullStylePointer, overrides, nil)
+	types.goType("numeric", false)
+	types.goType("uuid", false)
+	types.goType("timestamptz", false)
+
+	src := string(formatGoFile("db", types.imports(), []byte("var _ decimal.Decimal\nvar _ uuid.UUID\nvar _ time.Time\n")))
+	want := "import (\n\t\"time\"\n\n\tdecimal \"github.com/acme/go-decimal\"\n\t\"github.com/google/uuid\"\n)"
+	if !strings.Contains(src, want) {
+		t.Fatalf("expected imports %q in:\n%s", want, src)
+	}
+}